@@ -19,7 +19,9 @@
 package commands
 
 import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/leader"
 	"github.com/nalej/infrastructure-manager/internal/pkg/server"
+	"github.com/nalej/infrastructure-manager/internal/pkg/server/infrastructure"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -49,6 +51,65 @@ func init() {
 		"Installer address (host:port)")
 	runCmd.PersistentFlags().StringVar(&config.QueueAddress, "queueAddress", "localhost:6650",
 		"Queue system address (host:port)")
+	runCmd.PersistentFlags().BoolVar(&config.ProgressEvents, "progressEvents", false,
+		"Trigger the progress checks with the events published by the provisioner and the installer on the bus")
 	runCmd.PersistentFlags().StringVar(&config.TempDir, "tempDir", "", "Temporal directory for install related files")
+	runCmd.PersistentFlags().DurationVar(&config.IdempotencyKeyRetention, "idempotencyKeyRetention",
+		infrastructure.DefaultIdempotencyKeyRetention, "Time the responses of requests with an idempotency key are retained")
+	runCmd.PersistentFlags().DurationVar(&config.ProvisionDeadline, "provisionDeadline",
+		infrastructure.DefaultProvisionDeadline, "Maximum duration of a provision operation, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.InstallDeadline, "installDeadline",
+		infrastructure.DefaultInstallDeadline, "Maximum duration of an install operation, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.ScaleDeadline, "scaleDeadline",
+		infrastructure.DefaultScaleDeadline, "Maximum duration of a scale operation, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.UninstallDeadline, "uninstallDeadline",
+		infrastructure.DefaultUninstallDeadline, "Maximum duration of an uninstall operation, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.DecommissionDeadline, "decommissionDeadline",
+		infrastructure.DefaultDecommissionDeadline, "Maximum duration of a decommission operation, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.RemoveDeadline, "removeDeadline",
+		infrastructure.DefaultRemoveDeadline, "Maximum time to cordon and drain a cluster being removed, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.RemoveNodesDeadline, "removeNodesDeadline",
+		infrastructure.DefaultRemoveNodesDeadline, "Maximum time to drain each node being removed")
+	config.ProvisionPollPolicy = infrastructure.DefaultProvisionPollPolicy
+	runCmd.PersistentFlags().Var(&config.ProvisionPollPolicy, "provisionPollPolicy",
+		"Polling of provision operations as initial,max,multiplier,jitter,maxFailures")
+	config.InstallPollPolicy = infrastructure.DefaultInstallPollPolicy
+	runCmd.PersistentFlags().Var(&config.InstallPollPolicy, "installPollPolicy",
+		"Polling of install operations as initial,max,multiplier,jitter,maxFailures")
+	config.ScalePollPolicy = infrastructure.DefaultScalePollPolicy
+	runCmd.PersistentFlags().Var(&config.ScalePollPolicy, "scalePollPolicy",
+		"Polling of scale operations as initial,max,multiplier,jitter,maxFailures")
+	config.UninstallPollPolicy = infrastructure.DefaultUninstallPollPolicy
+	runCmd.PersistentFlags().Var(&config.UninstallPollPolicy, "uninstallPollPolicy",
+		"Polling of uninstall operations as initial,max,multiplier,jitter,maxFailures")
+	config.DecommissionPollPolicy = infrastructure.DefaultDecommissionPollPolicy
+	runCmd.PersistentFlags().Var(&config.DecommissionPollPolicy, "decommissionPollPolicy",
+		"Polling of decommission operations as initial,max,multiplier,jitter,maxFailures")
+	runCmd.PersistentFlags().DurationVar(&config.ShutdownTimeout, "shutdownTimeout",
+		infrastructure.DefaultShutdownTimeout, "Time given to checkpoint the running operations and to drain the requests on shutdown")
+	runCmd.PersistentFlags().DurationVar(&config.NodeReconcileInterval, "nodeReconcileInterval",
+		infrastructure.DefaultNodeReconcileInterval, "Time between two reconciliations of the nodes of the installed clusters, 0 to disable")
+	runCmd.PersistentFlags().DurationVar(&config.AutoscalerInterval, "autoscalerInterval",
+		infrastructure.DefaultAutoscalerInterval, "Time between two evaluations of the autoscaler policies, 0 to disable")
+	runCmd.PersistentFlags().StringVar(&config.AutoscalerPolicies, "autoscalerPolicies", "",
+		"Path of the JSON file with the autoscaler policies of the clusters, the autoscaler is disabled if not set")
+	runCmd.PersistentFlags().StringVar(&config.AutoscalerAuditLog, "autoscalerAuditLog", "",
+		"Path of the file where the autoscaler decisions are recorded, by default inside tempDir")
+	runCmd.PersistentFlags().StringVar(&config.Quotas, "quotas", "",
+		"Path of the JSON file with the infrastructure quotas of the organizations, unlimited if not set")
+	runCmd.PersistentFlags().BoolVar(&config.LeaderElection, "leaderElection", false,
+		"Elect a leader among the replicas to run the monitors, the journal is shared through secrets")
+	runCmd.PersistentFlags().StringVar(&config.Election.Namespace, "leaderElectionNamespace", "",
+		"Namespace of the Lease and the journal secrets")
+	runCmd.PersistentFlags().StringVar(&config.Election.LeaseName, "leaderElectionLease", leader.DefaultLeaseName,
+		"Name of the Lease used to elect the leader")
+	runCmd.PersistentFlags().StringVar(&config.Election.Identity, "leaderElectionIdentity", "",
+		"Identity of this replica in the election, the hostname by default")
+	runCmd.PersistentFlags().DurationVar(&config.Election.LeaseDuration, "leaderElectionLeaseDuration",
+		leader.DefaultLeaseDuration, "Time the followers wait before acquiring a lease that is not renewed")
+	runCmd.PersistentFlags().DurationVar(&config.Election.RenewDeadline, "leaderElectionRenewDeadline",
+		leader.DefaultRenewDeadline, "Time the leader tries to renew the lease before giving up the leadership")
+	runCmd.PersistentFlags().DurationVar(&config.Election.RetryPeriod, "leaderElectionRetryPeriod",
+		leader.DefaultRetryPeriod, "Time between attempts to acquire or renew the lease")
 	rootCmd.AddCommand(runCmd)
 }
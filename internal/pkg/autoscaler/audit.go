@@ -0,0 +1,64 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autoscaler
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"os"
+	"sync"
+)
+
+// AuditLog records the decisions of the autoscaler.
+type AuditLog interface {
+	// Record stores a decision.
+	Record(decision Decision) derrors.Error
+}
+
+// FileAuditLog appends each decision as a JSON line to a file.
+type FileAuditLog struct {
+	sync.Mutex
+	path string
+}
+
+// NewFileAuditLog creates an audit log on the given file. Existing decisions are kept.
+func NewFileAuditLog(path string) *FileAuditLog {
+	return &FileAuditLog{path: path}
+}
+
+// Record appends a decision to the file.
+func (al *FileAuditLog) Record(decision Decision) derrors.Error {
+	content, err := json.Marshal(decision)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal autoscaler decision")
+	}
+	al.Lock()
+	defer al.Unlock()
+	file, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return derrors.AsError(err, "cannot open autoscaler audit log")
+	}
+	_, err = file.Write(append(content, '\n'))
+	cErr := file.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return derrors.AsError(err, "cannot write autoscaler audit log")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autoscaler
+
+import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"math"
+	"time"
+)
+
+// Action is the outcome of the evaluation of a policy.
+type Action string
+
+const (
+	NoAction  Action = "none"
+	ScaleUp   Action = "scale_up"
+	ScaleDown Action = "scale_down"
+)
+
+// Decision contains the result of evaluating the policy of a cluster, and it is recorded for audit.
+type Decision struct {
+	Timestamp      int64   `json:"timestamp"`
+	RequestID      string  `json:"request_id,omitempty"`
+	OrganizationID string  `json:"organization_id"`
+	ClusterID      string  `json:"cluster_id"`
+	Action         Action  `json:"action"`
+	CurrentNodes   int     `json:"current_nodes"`
+	TargetNodes    int     `json:"target_nodes"`
+	Utilization    float64 `json:"utilization"`
+	PendingPods    int     `json:"pending_pods"`
+	Reason         string  `json:"reason"`
+	// Error contains the reason why the scale operation could not be launched.
+	Error string `json:"error,omitempty"`
+}
+
+// Evaluate decides the number of nodes that a cluster requires so that its utilization is close to the target of
+// its policy. Pending pods always require an additional node, and no scale is decided during the cooldown that
+// follows the last one unless the cluster is out of the limits of the policy.
+func Evaluate(policy Policy, usage entities.ClusterUsage, lastScale time.Time, now time.Time) Decision {
+	utilization := usage.Utilization()
+	decision := Decision{
+		Timestamp:      now.Unix(),
+		OrganizationID: policy.OrganizationID,
+		ClusterID:      policy.ClusterID,
+		Action:         NoAction,
+		CurrentNodes:   usage.Nodes,
+		TargetNodes:    usage.Nodes,
+		Utilization:    utilization,
+		PendingPods:    usage.PendingPods,
+	}
+	switch {
+	case usage.Nodes < policy.MinNodes:
+		decision.TargetNodes = policy.MinNodes
+		decision.Reason = "below min_nodes"
+	case usage.Nodes > policy.MaxNodes:
+		decision.TargetNodes = policy.MaxNodes
+		decision.Reason = "above max_nodes"
+	default:
+		// Only the schedulable nodes provide capacity, but the rest remain part of the cluster as the provisioner
+		// does not choose which nodes are removed.
+		unavailable := usage.Nodes - usage.SchedulableNodes
+		desired := unavailable + int(math.Ceil(float64(usage.SchedulableNodes)*utilization/policy.TargetUtilization))
+		reason := "utilization differs from target"
+		if usage.PendingPods > 0 && desired <= usage.Nodes {
+			desired = usage.Nodes + 1
+			reason = "pending pods"
+		}
+		desired = clamp(desired, policy.MinNodes, policy.MaxNodes)
+		if desired == usage.Nodes {
+			decision.Reason = "within policy"
+			return decision
+		}
+		if !lastScale.IsZero() && now.Sub(lastScale) < policy.Cooldown {
+			decision.Reason = "cooldown"
+			return decision
+		}
+		decision.TargetNodes = desired
+		decision.Reason = reason
+	}
+	decision.Action = ScaleUp
+	if decision.TargetNodes < decision.CurrentNodes {
+		decision.Action = ScaleDown
+	}
+	return decision
+}
+
+// clamp limits a value to a given range.
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
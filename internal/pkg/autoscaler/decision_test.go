@@ -0,0 +1,102 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autoscaler
+
+import (
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Autoscaler decisions", func() {
+
+	now := time.Now()
+
+	policy := Policy{
+		OrganizationID:    "org",
+		ClusterID:         "cluster",
+		MinNodes:          2,
+		MaxNodes:          5,
+		TargetUtilization: 0.5,
+		Cooldown:          time.Minute * 10,
+		ScaleTemplate:     &grpc_provisioner_go.ScaleClusterRequest{TargetPlatform: grpc_installer_go.Platform_BAREMETAL},
+	}
+
+	// usage returns the usage of a cluster whose nodes have 1000 millicores each.
+	usage := func(nodes int, requestedCPU int64, pendingPods int) entities.ClusterUsage {
+		return entities.ClusterUsage{
+			Nodes:            nodes,
+			SchedulableNodes: nodes,
+			AllocatableCPU:   int64(nodes) * 1000,
+			RequestedCPU:     requestedCPU,
+			PendingPods:      pendingPods,
+		}
+	}
+
+	ginkgo.It("should keep the nodes of a cluster close to the target", func() {
+		decision := Evaluate(policy, usage(3, 1500, 0), time.Time{}, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(NoAction))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("should scale up and down within the limits", func() {
+		decision := Evaluate(policy, usage(3, 2400, 0), time.Time{}, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(ScaleUp))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(5))
+		decision = Evaluate(policy, usage(4, 100, 0), time.Time{}, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(ScaleDown))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should add a node when there are pending pods", func() {
+		decision := Evaluate(policy, usage(3, 1200, 1), time.Time{}, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(ScaleUp))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(4))
+	})
+
+	ginkgo.It("should keep the nodes that are not ready as part of the cluster", func() {
+		notReady := usage(3, 1500, 0)
+		notReady.Nodes = 4
+		decision := Evaluate(policy, notReady, time.Time{}, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(NoAction))
+		gomega.Expect(decision.CurrentNodes).Should(gomega.Equal(4))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(4))
+	})
+
+	ginkgo.It("should wait for the cooldown unless the cluster is out of limits", func() {
+		lastScale := now.Add(-time.Minute)
+		decision := Evaluate(policy, usage(3, 2400, 0), lastScale, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(NoAction))
+		gomega.Expect(decision.Reason).Should(gomega.Equal("cooldown"))
+		decision = Evaluate(policy, usage(1, 0, 0), lastScale, now)
+		gomega.Expect(decision.Action).Should(gomega.Equal(ScaleUp))
+		gomega.Expect(decision.TargetNodes).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should reject inconsistent policies", func() {
+		gomega.Expect(policy.Validate()).To(gomega.Succeed())
+		invalid := policy
+		invalid.MaxNodes = 1
+		gomega.Expect(invalid.Validate()).ToNot(gomega.Succeed())
+		invalid = policy
+		invalid.TargetUtilization = 1.5
+		gomega.Expect(invalid.Validate()).ToNot(gomega.Succeed())
+	})
+})
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The autoscaler adjusts the number of nodes of the installed clusters following a policy defined for each of them.
+
+package autoscaler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"io/ioutil"
+	"time"
+)
+
+// Policy defines the limits and the target utilization of a cluster.
+type Policy struct {
+	OrganizationID string `json:"organization_id"`
+	ClusterID      string `json:"cluster_id"`
+	MinNodes       int    `json:"min_nodes"`
+	MaxNodes       int    `json:"max_nodes"`
+	// TargetUtilization is the ratio between the requested and the allocatable resources to maintain, between 0 and 1.
+	TargetUtilization float64 `json:"target_utilization"`
+	// Cooldown is the minimum time between two scale operations launched by the autoscaler.
+	Cooldown time.Duration `json:"-"`
+	// ScaleTemplate contains the platform specific fields of the scale requests, such as the credentials.
+	ScaleTemplate *grpc_provisioner_go.ScaleClusterRequest `json:"scale_template"`
+}
+
+// policyEntry is the representation of a policy in the policy file, where the cooldown is written as a duration.
+type policyEntry struct {
+	Policy
+	Cooldown string `json:"cooldown"`
+}
+
+// Key returns the identifier of the cluster of the policy.
+func (p *Policy) Key() string {
+	return fmt.Sprintf("%s/%s", p.OrganizationID, p.ClusterID)
+}
+
+// Validate checks that the values of the policy are consistent.
+func (p *Policy) Validate() derrors.Error {
+	if p.OrganizationID == "" || p.ClusterID == "" {
+		return derrors.NewInvalidArgumentError("autoscaler policy must contain organization_id and cluster_id")
+	}
+	if p.MinNodes < 1 {
+		return derrors.NewInvalidArgumentError("min_nodes must be positive").WithParams(p.Key())
+	}
+	if p.MaxNodes < p.MinNodes {
+		return derrors.NewInvalidArgumentError("max_nodes cannot be lower than min_nodes").WithParams(p.Key())
+	}
+	if p.TargetUtilization <= 0 || p.TargetUtilization > 1 {
+		return derrors.NewInvalidArgumentError("target_utilization must be between 0 and 1").WithParams(p.Key())
+	}
+	if p.Cooldown < 0 {
+		return derrors.NewInvalidArgumentError("cooldown cannot be negative").WithParams(p.Key())
+	}
+	if p.ScaleTemplate == nil {
+		return derrors.NewInvalidArgumentError("scale_template cannot be empty").WithParams(p.Key())
+	}
+	return entities.ValidScaleClusterRequest(p.ScaleRequest("", p.MinNodes))
+}
+
+// ScaleRequest builds the request that sets the number of nodes of the cluster.
+func (p *Policy) ScaleRequest(requestID string, numNodes int) *grpc_provisioner_go.ScaleClusterRequest {
+	request := *p.ScaleTemplate
+	request.RequestId = requestID
+	request.OrganizationId = p.OrganizationID
+	request.ClusterId = p.ClusterID
+	request.NumNodes = int64(numNodes)
+	return &request
+}
+
+// LoadPolicies reads a JSON file with a list of policies, such as
+// [{"organization_id": "org", "cluster_id": "cluster", "min_nodes": 1, "max_nodes": 5, "target_utilization": 0.7,
+// "cooldown": "10m", "scale_template": {"target_platform": 0, ...}}]. Each cluster can only have one policy.
+func LoadPolicies(path string) (map[string]Policy, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read autoscaler policies")
+	}
+	entries := make([]policyEntry, 0)
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot unmarshal autoscaler policies")
+	}
+	result := make(map[string]Policy, len(entries))
+	for _, entry := range entries {
+		policy := entry.Policy
+		if entry.Cooldown != "" {
+			cooldown, pErr := time.ParseDuration(entry.Cooldown)
+			if pErr != nil {
+				return nil, derrors.AsError(pErr, "invalid cooldown in autoscaler policy")
+			}
+			policy.Cooldown = cooldown
+		}
+		vErr := policy.Validate()
+		if vErr != nil {
+			return nil, vErr
+		}
+		if _, exists := result[policy.Key()]; exists {
+			return nil, derrors.NewAlreadyExistsError("duplicated autoscaler policy").WithParams(policy.Key())
+		}
+		result[policy.Key()] = policy
+	}
+	return result, nil
+}
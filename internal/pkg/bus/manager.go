@@ -27,6 +27,14 @@ import (
 
 // Structures and operators designed to manipulate the queue operations for the infrastructure ops queue.
 
+// Sender defines the messages the infrastructure manager publishes on the bus.
+type Sender interface {
+	// SendOps sends a new operation.
+	SendOps(ctx context.Context, msg proto.Message) derrors.Error
+	// SendEvents sends a new event.
+	SendEvents(ctx context.Context, msg proto.Message) derrors.Error
+}
+
 type BusManager struct {
 	producerOps    *ops.InfrastructureOpsProducer
 	producerEvents *events.InfrastructureEventsProducer
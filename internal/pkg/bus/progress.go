@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bus
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/nalej-bus/pkg/bus"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// Topics where the provisioner and the installer publish the progress of their operations. Events are expected
+// to be grpc_common_go.OpResponse messages.
+const (
+	ProvisionerProgressTopic = "nalej/provisioner/progress"
+	InstallerProgressTopic   = "nalej/installer/progress"
+)
+
+// ReceiveRetryDelay contains the time to wait before receiving again after an error on the bus.
+const ReceiveRetryDelay = time.Second * 5
+
+// ProgressConsumer receives the progress events of the provisioner and installer operations, and notifies the
+// monitors subscribed to the associated requests.
+type ProgressConsumer struct {
+	sync.Mutex
+	consumers   map[string]bus.NalejConsumer
+	subscribers map[string][]chan struct{}
+	// ctx is canceled when the consumer is stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewProgressConsumer creates a consumer attached to the progress topics.
+func NewProgressConsumer(client bus.NalejClient, name string) (*ProgressConsumer, derrors.Error) {
+	consumers := make(map[string]bus.NalejConsumer, 0)
+	for _, topic := range []string{ProvisionerProgressTopic, InstallerProgressTopic} {
+		consumer, err := client.BuildConsumer(name, topic, false)
+		if err != nil {
+			return nil, err
+		}
+		consumers[topic] = consumer
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ProgressConsumer{
+		consumers:   consumers,
+		subscribers: make(map[string][]chan struct{}, 0),
+		ctx:         ctx,
+		cancel:      cancel,
+	}, nil
+}
+
+// Run launches the consumption of the progress topics in background.
+func (pc *ProgressConsumer) Run() {
+	for topic, consumer := range pc.consumers {
+		go pc.consume(topic, consumer)
+	}
+}
+
+// Stop ends the consumption of the progress topics.
+func (pc *ProgressConsumer) Stop() {
+	pc.cancel()
+}
+
+// consume receives the messages of a topic and dispatches them to the subscribers.
+func (pc *ProgressConsumer) consume(topic string, consumer bus.NalejConsumer) {
+	log.Info().Str("topic", topic).Msg("consuming progress events")
+	for {
+		msg, err := consumer.Receive(pc.ctx)
+		if pc.ctx.Err() != nil {
+			log.Info().Str("topic", topic).Msg("progress consumer stopped")
+			return
+		}
+		if err != nil {
+			log.Error().Str("topic", topic).Str("trace", err.DebugReport()).Msg("error receiving progress event")
+			select {
+			case <-time.After(ReceiveRetryDelay):
+			case <-pc.ctx.Done():
+			}
+			continue
+		}
+		event := &grpc_common_go.OpResponse{}
+		uErr := proto.Unmarshal(msg, event)
+		if uErr != nil {
+			log.Error().Str("topic", topic).Err(uErr).Msg("cannot unmarshal progress event")
+			continue
+		}
+		log.Debug().Str("topic", topic).Str("requestID", event.RequestId).
+			Str("status", event.Status.String()).Msg("progress event received")
+		pc.notify(event.RequestId)
+	}
+}
+
+// notify informs the subscribers of a request that a progress event has been received. Notifications are not
+// queued, a pending notification is enough for the monitor to check the progress of the operation.
+func (pc *ProgressConsumer) notify(requestID string) {
+	pc.Lock()
+	defer pc.Unlock()
+	for _, ch := range pc.subscribers[requestID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that is notified each time a progress event is received for a given request.
+func (pc *ProgressConsumer) Subscribe(requestID string) <-chan struct{} {
+	pc.Lock()
+	defer pc.Unlock()
+	ch := make(chan struct{}, 1)
+	pc.subscribers[requestID] = append(pc.subscribers[requestID], ch)
+	return ch
+}
+
+// Unsubscribe removes a channel previously obtained with Subscribe.
+func (pc *ProgressConsumer) Unsubscribe(requestID string, events <-chan struct{}) {
+	pc.Lock()
+	defer pc.Unlock()
+	remaining := make([]chan struct{}, 0, len(pc.subscribers[requestID]))
+	for _, ch := range pc.subscribers[requestID] {
+		if (<-chan struct{})(ch) != events {
+			remaining = append(remaining, ch)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(pc.subscribers, requestID)
+	} else {
+		pc.subscribers[requestID] = remaining
+	}
+}
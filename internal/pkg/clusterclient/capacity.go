@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterclient
+
+import (
+	"github.com/nalej/derrors"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// NodeUsage contains the resources of a node and the pods that run on it. CPU values are expressed in millicores and
+// memory values in bytes.
+type NodeUsage struct {
+	Name    string
+	Created time.Time
+	// Schedulable is set if the node is ready and accepts new pods.
+	Schedulable       bool
+	AllocatableCPU    int64
+	AllocatableMemory int64
+	// RequestedCPU and RequestedMemory contain the requests of all the active pods of the node.
+	RequestedCPU    int64
+	RequestedMemory int64
+	// Evictable contains the pods that would be evicted when draining the node.
+	Evictable []coreV1.Pod
+}
+
+// FreeCPU returns the allocatable CPU not requested by any pod.
+func (nu *NodeUsage) FreeCPU() int64 {
+	return nu.AllocatableCPU - nu.RequestedCPU
+}
+
+// FreeMemory returns the allocatable memory not requested by any pod.
+func (nu *NodeUsage) FreeMemory() int64 {
+	return nu.AllocatableMemory - nu.RequestedMemory
+}
+
+// NodesUsage retrieves the resources and the pods of every node of the cluster.
+func (cc *ClusterClient) NodesUsage() ([]NodeUsage, derrors.Error) {
+	nodes, err := cc.client.CoreV1().Nodes().List(metaV1.ListOptions{})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list nodes")
+	}
+	pods, err := cc.client.CoreV1().Pods(metaV1.NamespaceAll).List(metaV1.ListOptions{})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list pods")
+	}
+	byName := make(map[string]*NodeUsage, len(nodes.Items))
+	result := make([]NodeUsage, len(nodes.Items))
+	for i, node := range nodes.Items {
+		result[i] = NodeUsage{
+			Name:              node.Name,
+			Created:           node.CreationTimestamp.Time,
+			Schedulable:       !node.Spec.Unschedulable && nodeReady(node),
+			AllocatableCPU:    node.Status.Allocatable.Cpu().MilliValue(),
+			AllocatableMemory: node.Status.Allocatable.Memory().Value(),
+			Evictable:         make([]coreV1.Pod, 0),
+		}
+		byName[node.Name] = &result[i]
+	}
+	for _, pod := range pods.Items {
+		usage, exists := byName[pod.Spec.NodeName]
+		if !exists || pod.Status.Phase == coreV1.PodSucceeded || pod.Status.Phase == coreV1.PodFailed {
+			continue
+		}
+		cpu, memory := PodRequests(pod)
+		usage.RequestedCPU += cpu
+		usage.RequestedMemory += memory
+		if mustEvict(pod) {
+			usage.Evictable = append(usage.Evictable, pod)
+		}
+	}
+	return result, nil
+}
+
+// PodRequests returns the CPU and memory requested by the containers of a pod.
+func PodRequests(pod coreV1.Pod) (int64, int64) {
+	var cpu, memory int64
+	for _, container := range pod.Spec.Containers {
+		cpu += container.Resources.Requests.Cpu().MilliValue()
+		memory += container.Resources.Requests.Memory().Value()
+	}
+	return cpu, memory
+}
+
+// nodeReady checks if the kubelet of a node reports it as ready.
+func nodeReady(node coreV1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == coreV1.NodeReady {
+			return condition.Status == coreV1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The cluster client performs operations on the Kubernetes API of the application clusters.
+
+package clusterclient
+
+import (
+	"github.com/nalej/derrors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClusterClient manipulates the resources of an application cluster.
+type ClusterClient struct {
+	client kubernetes.Interface
+}
+
+// NewClusterClient creates a client for the cluster described by a kubeconfig.
+func NewClusterClient(kubeConfigRaw string) (*ClusterClient, derrors.Error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeConfigRaw))
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot load kubeconfig")
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create kubernetes client")
+	}
+	return &ClusterClient{client: client}, nil
+}
+
+// NewClusterClientFromInterface creates a cluster client on top of an existing Kubernetes client.
+func NewClusterClientFromInterface(client kubernetes.Interface) *ClusterClient {
+	return &ClusterClient{client: client}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	coreV1 "k8s.io/api/core/v1"
+	policyV1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+const (
+	// mirrorPodAnnotation identifies the static pods managed directly by the kubelet.
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	// EvictionRetryDelay is the time between attempts to evict the pods of a node.
+	EvictionRetryDelay = 5 * time.Second
+)
+
+// FindNodeByIP returns the name of the node that has a given address.
+func (cc *ClusterClient) FindNodeByIP(ip string) (string, derrors.Error) {
+	nodes, err := cc.client.CoreV1().Nodes().List(metaV1.ListOptions{})
+	if err != nil {
+		return "", derrors.AsError(err, "cannot list nodes")
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Address == ip {
+				return node.Name, nil
+			}
+		}
+	}
+	return "", derrors.NewNotFoundError("node not found in kubernetes").WithParams(ip)
+}
+
+// CordonNode marks a node as unschedulable.
+func (cc *ClusterClient) CordonNode(name string) derrors.Error {
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	_, err := cc.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, patch)
+	if err != nil {
+		return derrors.AsError(err, "cannot cordon node")
+	}
+	log.Debug().Str("node", name).Msg("node cordoned")
+	return nil
+}
+
+// UncordonNode marks a node as schedulable again.
+func (cc *ClusterClient) UncordonNode(name string) derrors.Error {
+	patch := []byte(`{"spec":{"unschedulable":false}}`)
+	_, err := cc.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, patch)
+	if err != nil {
+		return derrors.AsError(err, "cannot uncordon node")
+	}
+	log.Debug().Str("node", name).Msg("node uncordoned")
+	return nil
+}
+
+// UncordonExistingNodes marks as schedulable again the nodes of a list that still exist, and returns their names.
+// Nodes that have been deleted are skipped.
+func (cc *ClusterClient) UncordonExistingNodes(names []string) ([]string, derrors.Error) {
+	uncordoned := make([]string, 0, len(names))
+	for _, name := range names {
+		patch := []byte(`{"spec":{"unschedulable":false}}`)
+		_, err := cc.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, patch)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return uncordoned, derrors.AsError(err, "cannot uncordon node")
+		}
+		uncordoned = append(uncordoned, name)
+	}
+	return uncordoned, nil
+}
+
+// DeleteNode removes a node from the cluster.
+func (cc *ClusterClient) DeleteNode(name string) derrors.Error {
+	err := cc.client.CoreV1().Nodes().Delete(name, &metaV1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return derrors.AsError(err, "cannot delete node")
+	}
+	log.Debug().Str("node", name).Msg("node deleted")
+	return nil
+}
+
+// UpdateNodeLabels sets and removes labels of a node. Removals take precedence if a key appears in both.
+func (cc *ClusterClient) UpdateNodeLabels(name string, add map[string]string, remove []string) derrors.Error {
+	labels := make(map[string]interface{}, len(add)+len(remove))
+	for key, value := range add {
+		labels[key] = value
+	}
+	// A null value removes the key on a merge patch.
+	for _, key := range remove {
+		labels[key] = nil
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return derrors.AsError(err, "cannot build labels patch")
+	}
+	_, err = cc.client.CoreV1().Nodes().Patch(name, types.MergePatchType, patch)
+	if err != nil {
+		return derrors.AsError(err, "cannot update node labels")
+	}
+	log.Debug().Str("node", name).Int("added", len(add)).Int("removed", len(remove)).Msg("node labels updated")
+	return nil
+}
+
+// DrainNode evicts the pods running on a node and waits until they are gone. Pods managed by a DaemonSet and
+// static pods are not evicted as they cannot be rescheduled elsewhere. Evictions refused because of a disruption
+// budget are retried until the timeout expires.
+func (cc *ClusterClient) DrainNode(name string, timeout time.Duration) derrors.Error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := cc.podsToEvict(name)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			log.Debug().Str("node", name).Msg("node drained")
+			return nil
+		}
+		for _, pod := range pods {
+			eErr := cc.client.CoreV1().Pods(pod.Namespace).Evict(&policyV1beta1.Eviction{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name:      pod.Name,
+					Namespace: pod.Namespace,
+				},
+			})
+			if eErr != nil && !errors.IsNotFound(eErr) {
+				log.Debug().Str("node", name).Str("pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)).
+					Err(eErr).Msg("pod eviction refused, retrying")
+			}
+		}
+		if time.Now().After(deadline) {
+			return derrors.NewDeadlineExceededError("node was not drained before the timeout").WithParams(name, len(pods))
+		}
+		time.Sleep(EvictionRetryDelay)
+	}
+}
+
+// podsToEvict returns the pods of a node that must be evicted.
+func (cc *ClusterClient) podsToEvict(nodeName string) ([]coreV1.Pod, derrors.Error) {
+	pods, err := cc.client.CoreV1().Pods(metaV1.NamespaceAll).List(metaV1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": nodeName}).String(),
+	})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list pods of the node")
+	}
+	result := make([]coreV1.Pod, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == nodeName && mustEvict(pod) {
+			result = append(result, pod)
+		}
+	}
+	return result, nil
+}
+
+// mustEvict checks if a pod has to be evicted to drain its node. Finished pods, static pods and pods managed by a
+// DaemonSet are left on the node.
+func mustEvict(pod coreV1.Pod) bool {
+	if pod.Status.Phase == coreV1.PodSucceeded || pod.Status.Phase == coreV1.PodFailed {
+		return false
+	}
+	if _, isMirror := pod.Annotations[mirrorPodAnnotation]; isMirror {
+		return false
+	}
+	return !isDaemonSetPod(pod)
+}
+
+// isDaemonSetPod checks if a pod is managed by a DaemonSet.
+func isDaemonSetPod(pod coreV1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
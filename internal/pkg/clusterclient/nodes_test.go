@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterclient
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = ginkgo.Describe("Node operations", func() {
+
+	var client *fake.Clientset
+	var clusterClient *ClusterClient
+
+	ginkgo.BeforeEach(func() {
+		client = fake.NewSimpleClientset(
+			&coreV1.Node{
+				ObjectMeta: metaV1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "a", "old": "true"}},
+				Status: coreV1.NodeStatus{Addresses: []coreV1.NodeAddress{
+					{Type: coreV1.NodeHostName, Address: "node1"},
+					{Type: coreV1.NodeInternalIP, Address: "10.0.0.1"},
+				}},
+			},
+			&coreV1.Pod{
+				ObjectMeta: metaV1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       coreV1.PodSpec{NodeName: "node1"},
+			},
+			&coreV1.Pod{
+				ObjectMeta: metaV1.ObjectMeta{Name: "agent", Namespace: "default",
+					OwnerReferences: []metaV1.OwnerReference{{Kind: "DaemonSet", Name: "agent"}}},
+				Spec: coreV1.PodSpec{NodeName: "node1"},
+			},
+			&coreV1.Pod{
+				ObjectMeta: metaV1.ObjectMeta{Name: "other", Namespace: "default"},
+				Spec:       coreV1.PodSpec{NodeName: "node2"},
+			},
+		)
+		clusterClient = NewClusterClientFromInterface(client)
+	})
+
+	ginkgo.It("should find a node by any of its addresses", func() {
+		name, err := clusterClient.FindNodeByIP("10.0.0.1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(name).Should(gomega.Equal("node1"))
+		_, err = clusterClient.FindNodeByIP("10.0.0.2")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should cordon and delete a node", func() {
+		gomega.Expect(clusterClient.CordonNode("node1")).To(gomega.Succeed())
+		node, err := client.CoreV1().Nodes().Get("node1", metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(node.Spec.Unschedulable).Should(gomega.BeTrue())
+		gomega.Expect(clusterClient.DeleteNode("node1")).To(gomega.Succeed())
+		_, err = client.CoreV1().Nodes().Get("node1", metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should only uncordon the nodes that still exist", func() {
+		gomega.Expect(clusterClient.CordonNode("node1")).To(gomega.Succeed())
+		uncordoned, err := clusterClient.UncordonExistingNodes([]string{"node1", "node2"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(uncordoned).Should(gomega.Equal([]string{"node1"}))
+		node, err := client.CoreV1().Nodes().Get("node1", metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(node.Spec.Unschedulable).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should add and remove node labels", func() {
+		gomega.Expect(clusterClient.UpdateNodeLabels("node1", map[string]string{"zone": "b", "gpu": "true"}, []string{"old"})).To(gomega.Succeed())
+		node, err := client.CoreV1().Nodes().Get("node1", metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(node.Labels).Should(gomega.Equal(map[string]string{"zone": "b", "gpu": "true"}))
+	})
+
+	ginkgo.It("should only evict the pods that can be rescheduled", func() {
+		pods, err := clusterClient.podsToEvict("node1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(pods)).Should(gomega.Equal(1))
+		gomega.Expect(pods[0].Name).Should(gomega.Equal("app"))
+	})
+})
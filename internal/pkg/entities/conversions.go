@@ -22,6 +22,8 @@ import (
 	"github.com/nalej/grpc-installer-go"
 )
 
+// StateToStatus maps the progress of an install into the status of the infrastructure. Unknown values are
+// considered errors.
 func StateToStatus(state grpc_installer_go.InstallProgress) grpc_infrastructure_go.InfraStatus {
 	var newStatus grpc_infrastructure_go.InfraStatus
 	switch state {
@@ -33,10 +35,14 @@ func StateToStatus(state grpc_installer_go.InstallProgress) grpc_infrastructure_
 		newStatus = grpc_infrastructure_go.InfraStatus_RUNNING
 	case grpc_installer_go.InstallProgress_ERROR:
 		newStatus = grpc_infrastructure_go.InfraStatus_ERROR
+	default:
+		newStatus = grpc_infrastructure_go.InfraStatus_ERROR
 	}
 	return newStatus
 }
 
+// InstallStateToNodeState maps the progress of an install into the state of the nodes. Unknown values leave the
+// nodes unregistered.
 func InstallStateToNodeState(state grpc_installer_go.InstallProgress) grpc_infrastructure_go.NodeState {
 	var newState grpc_infrastructure_go.NodeState
 	switch state {
@@ -48,10 +54,14 @@ func InstallStateToNodeState(state grpc_installer_go.InstallProgress) grpc_infra
 		newState = grpc_infrastructure_go.NodeState_ASSIGNED
 	case grpc_installer_go.InstallProgress_ERROR:
 		newState = grpc_infrastructure_go.NodeState_UNREGISTERED
+	default:
+		newState = grpc_infrastructure_go.NodeState_UNREGISTERED
 	}
 	return newState
 }
 
+// OpStatusToNodeState maps the status of an install operation into the state of the nodes. Unknown values leave
+// the nodes unregistered.
 func OpStatusToNodeState(status grpc_common_go.OpStatus) grpc_infrastructure_go.NodeState {
 	var newState grpc_infrastructure_go.NodeState
 	switch status {
@@ -65,6 +75,8 @@ func OpStatusToNodeState(status grpc_common_go.OpStatus) grpc_infrastructure_go.
 		newState = grpc_infrastructure_go.NodeState_ASSIGNED
 	case grpc_common_go.OpStatus_FAILED:
 		newState = grpc_infrastructure_go.NodeState_UNREGISTERED
+	default:
+		newState = grpc_infrastructure_go.NodeState_UNREGISTERED
 	}
 	return newState
 }
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Conversions", func() {
+
+	// unknown is a value that is not defined in any of the protocol enums.
+	const unknown = 1000
+
+	ginkgo.It("should map every install progress", func() {
+		expectedStatus := map[grpc_installer_go.InstallProgress]grpc_infrastructure_go.InfraStatus{
+			grpc_installer_go.InstallProgress_REGISTERED:  grpc_infrastructure_go.InfraStatus_INSTALLING,
+			grpc_installer_go.InstallProgress_IN_PROGRESS: grpc_infrastructure_go.InfraStatus_INSTALLING,
+			grpc_installer_go.InstallProgress_FINISHED:    grpc_infrastructure_go.InfraStatus_RUNNING,
+			grpc_installer_go.InstallProgress_ERROR:       grpc_infrastructure_go.InfraStatus_ERROR,
+		}
+		expectedState := map[grpc_installer_go.InstallProgress]grpc_infrastructure_go.NodeState{
+			grpc_installer_go.InstallProgress_REGISTERED:  grpc_infrastructure_go.NodeState_ASSIGNED,
+			grpc_installer_go.InstallProgress_IN_PROGRESS: grpc_infrastructure_go.NodeState_ASSIGNED,
+			grpc_installer_go.InstallProgress_FINISHED:    grpc_infrastructure_go.NodeState_ASSIGNED,
+			grpc_installer_go.InstallProgress_ERROR:       grpc_infrastructure_go.NodeState_UNREGISTERED,
+		}
+		gomega.Expect(len(expectedStatus)).Should(gomega.Equal(len(grpc_installer_go.InstallProgress_name)))
+		for progress, status := range expectedStatus {
+			gomega.Expect(StateToStatus(progress)).Should(gomega.Equal(status))
+			gomega.Expect(InstallStateToNodeState(progress)).Should(gomega.Equal(expectedState[progress]))
+		}
+	})
+
+	ginkgo.It("should map every operation status", func() {
+		expected := map[grpc_common_go.OpStatus]grpc_infrastructure_go.NodeState{
+			grpc_common_go.OpStatus_INIT:       grpc_infrastructure_go.NodeState_UNASSIGNED,
+			grpc_common_go.OpStatus_SCHEDULED:  grpc_infrastructure_go.NodeState_UNASSIGNED,
+			grpc_common_go.OpStatus_INPROGRESS: grpc_infrastructure_go.NodeState_UNASSIGNED,
+			grpc_common_go.OpStatus_SUCCESS:    grpc_infrastructure_go.NodeState_ASSIGNED,
+			grpc_common_go.OpStatus_FAILED:     grpc_infrastructure_go.NodeState_UNREGISTERED,
+			grpc_common_go.OpStatus_CANCELED:   grpc_infrastructure_go.NodeState_UNREGISTERED,
+		}
+		gomega.Expect(len(expected)).Should(gomega.Equal(len(grpc_common_go.OpStatus_name)))
+		for status, state := range expected {
+			gomega.Expect(OpStatusToNodeState(status)).Should(gomega.Equal(state))
+		}
+	})
+
+	ginkgo.It("should handle unknown values explicitly", func() {
+		gomega.Expect(StateToStatus(grpc_installer_go.InstallProgress(unknown))).Should(gomega.Equal(grpc_infrastructure_go.InfraStatus_ERROR))
+		gomega.Expect(InstallStateToNodeState(grpc_installer_go.InstallProgress(unknown))).Should(gomega.Equal(grpc_infrastructure_go.NodeState_UNREGISTERED))
+		gomega.Expect(OpStatusToNodeState(grpc_common_go.OpStatus(unknown))).Should(gomega.Equal(grpc_infrastructure_go.NodeState_UNREGISTERED))
+	})
+})
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The cluster lifecycle defines the state transitions that each operation may perform on a cluster.
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+)
+
+// RegisterOperation is the pseudo-operation that sets the initial state of a cluster that has just been added to
+// system model, either because it is going to be provisioned or because it has been discovered.
+const RegisterOperation OperationType = "register"
+
+// StateTransition represents a change in the state of a cluster.
+type StateTransition struct {
+	From grpc_infrastructure_go.ClusterState
+	To   grpc_infrastructure_go.ClusterState
+}
+
+// clusterLifecycle contains the legal transitions of each operation.
+var clusterLifecycle = map[OperationType][]StateTransition{
+	ProvisionOperation: {
+		{grpc_infrastructure_go.ClusterState_PROVISIONING, grpc_infrastructure_go.ClusterState_PROVISIONED},
+		{grpc_infrastructure_go.ClusterState_PROVISIONING, grpc_infrastructure_go.ClusterState_FAILURE},
+	},
+	InstallOperation: {
+		{grpc_infrastructure_go.ClusterState_PROVISIONED, grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS},
+		{grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS, grpc_infrastructure_go.ClusterState_INSTALLED},
+		{grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS, grpc_infrastructure_go.ClusterState_FAILURE},
+	},
+	ScaleOperation: {
+		{grpc_infrastructure_go.ClusterState_INSTALLED, grpc_infrastructure_go.ClusterState_SCALING},
+		{grpc_infrastructure_go.ClusterState_SCALING, grpc_infrastructure_go.ClusterState_INSTALLED},
+		{grpc_infrastructure_go.ClusterState_SCALING, grpc_infrastructure_go.ClusterState_FAILURE},
+	},
+	UninstallOperation: {
+		{grpc_infrastructure_go.ClusterState_INSTALLED, grpc_infrastructure_go.ClusterState_UNINSTALLING},
+		{grpc_infrastructure_go.ClusterState_FAILURE, grpc_infrastructure_go.ClusterState_UNINSTALLING},
+		{grpc_infrastructure_go.ClusterState_UNINSTALLING, grpc_infrastructure_go.ClusterState_PROVISIONED},
+		{grpc_infrastructure_go.ClusterState_UNINSTALLING, grpc_infrastructure_go.ClusterState_FAILURE},
+	},
+	// The decommission removes the cluster from system model once it finishes, so its state only changes if it fails.
+	DecommissionOperation: {
+		{grpc_infrastructure_go.ClusterState_PROVISIONED, grpc_infrastructure_go.ClusterState_FAILURE},
+	},
+	// The removal only cordons and drains the cluster, its state is changed by the uninstall that follows.
+	RemoveOperation: {},
+}
+
+// registerStates contains the states that may be set on a newly registered cluster.
+var registerStates = map[grpc_infrastructure_go.ClusterState]bool{
+	grpc_infrastructure_go.ClusterState_PROVISIONING: true,
+	grpc_infrastructure_go.ClusterState_PROVISIONED:  true,
+}
+
+// ValidClusterTransition checks if an operation may move a cluster from one state to another.
+func ValidClusterTransition(operation OperationType, from grpc_infrastructure_go.ClusterState, to grpc_infrastructure_go.ClusterState) derrors.Error {
+	if operation == RegisterOperation {
+		if registerStates[to] {
+			return nil
+		}
+		return derrors.NewFailedPreconditionError("invalid initial cluster state").WithParams(to.String())
+	}
+	transitions, exists := clusterLifecycle[operation]
+	if !exists {
+		return derrors.NewInvalidArgumentError("unknown operation").WithParams(operation)
+	}
+	for _, t := range transitions {
+		if t.From == from && t.To == to {
+			return nil
+		}
+	}
+	return derrors.NewFailedPreconditionError("invalid cluster state transition").
+		WithParams(operation, from.String(), to.String())
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// allClusterStates returns every state defined in the protocol.
+func allClusterStates() []grpc_infrastructure_go.ClusterState {
+	result := make([]grpc_infrastructure_go.ClusterState, 0, len(grpc_infrastructure_go.ClusterState_name))
+	for value := range grpc_infrastructure_go.ClusterState_name {
+		result = append(result, grpc_infrastructure_go.ClusterState(value))
+	}
+	return result
+}
+
+var _ = ginkgo.Describe("Cluster lifecycle", func() {
+
+	const (
+		provisioning      = grpc_infrastructure_go.ClusterState_PROVISIONING
+		provisioned       = grpc_infrastructure_go.ClusterState_PROVISIONED
+		installInProgress = grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS
+		installed         = grpc_infrastructure_go.ClusterState_INSTALLED
+		scaling           = grpc_infrastructure_go.ClusterState_SCALING
+		uninstalling      = grpc_infrastructure_go.ClusterState_UNINSTALLING
+		failure           = grpc_infrastructure_go.ClusterState_FAILURE
+	)
+
+	// expected contains the legal transitions of each operation, any other pair of states must be rejected.
+	expected := map[OperationType][]StateTransition{
+		ProvisionOperation: {
+			{provisioning, provisioned},
+			{provisioning, failure},
+		},
+		InstallOperation: {
+			{provisioned, installInProgress},
+			{installInProgress, installed},
+			{installInProgress, failure},
+		},
+		ScaleOperation: {
+			{installed, scaling},
+			{scaling, installed},
+			{scaling, failure},
+		},
+		UninstallOperation: {
+			{installed, uninstalling},
+			{failure, uninstalling},
+			{uninstalling, provisioned},
+			{uninstalling, failure},
+		},
+		DecommissionOperation: {
+			{provisioned, failure},
+		},
+		RemoveOperation: {},
+	}
+
+	isExpected := func(operation OperationType, from grpc_infrastructure_go.ClusterState, to grpc_infrastructure_go.ClusterState) bool {
+		for _, t := range expected[operation] {
+			if t.From == from && t.To == to {
+				return true
+			}
+		}
+		return false
+	}
+
+	ginkgo.It("should only accept the legal transitions of each operation", func() {
+		for operation := range expected {
+			for _, from := range allClusterStates() {
+				for _, to := range allClusterStates() {
+					err := ValidClusterTransition(operation, from, to)
+					if isExpected(operation, from, to) {
+						gomega.Expect(err).To(gomega.Succeed(), "%s: %s -> %s", operation, from, to)
+					} else {
+						gomega.Expect(err).ToNot(gomega.Succeed(), "%s: %s -> %s", operation, from, to)
+					}
+				}
+			}
+		}
+	})
+
+	ginkgo.It("should define the transitions of every operation", func() {
+		gomega.Expect(len(clusterLifecycle)).Should(gomega.Equal(len(expected)))
+	})
+
+	ginkgo.It("should only register clusters as provisioning or provisioned", func() {
+		for _, from := range allClusterStates() {
+			for _, to := range allClusterStates() {
+				err := ValidClusterTransition(RegisterOperation, from, to)
+				if to == provisioning || to == provisioned {
+					gomega.Expect(err).To(gomega.Succeed())
+				} else {
+					gomega.Expect(err).ToNot(gomega.Succeed())
+				}
+			}
+		}
+	})
+
+	ginkgo.It("should reject unknown operations", func() {
+		gomega.Expect(ValidClusterTransition(OperationType("unknown"), provisioned, installInProgress)).ToNot(gomega.Succeed())
+	})
+})
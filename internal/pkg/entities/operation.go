@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+)
+
+// OperationType defines the long-running operations coordinated by the infrastructure manager.
+type OperationType string
+
+const (
+	ProvisionOperation    OperationType = "provision"
+	InstallOperation      OperationType = "install"
+	ScaleOperation        OperationType = "scale"
+	UninstallOperation    OperationType = "uninstall"
+	DecommissionOperation OperationType = "decommission"
+	// RemoveOperation cordons and drains an imported cluster before uninstalling it and removing it from system model.
+	RemoveOperation OperationType = "remove"
+	// RemoveNodesOperation cordons, drains and removes a set of nodes of a cluster.
+	RemoveNodesOperation OperationType = "remove_nodes"
+	// ReconcileNodesOperation updates system model with the nodes found in a cluster. It is only used to lock the
+	// cluster during the reconciliation.
+	ReconcileNodesOperation OperationType = "reconcile_nodes"
+)
+
+// Operation contains the information required to follow an ongoing operation on the provisioner or
+// the installer, and to resume its monitoring if the infrastructure manager is restarted.
+type Operation struct {
+	RequestID      string        `json:"request_id"`
+	OrganizationID string        `json:"organization_id"`
+	ClusterID      string        `json:"cluster_id"`
+	Type           OperationType `json:"type"`
+	// Created contains the timestamp when the operation was launched.
+	Created int64 `json:"created"`
+	// Decommission contains the request that will be sent to the provisioner once an uninstall finishes.
+	Decommission *grpc_provisioner_go.DecommissionClusterRequest `json:"decommission,omitempty"`
+	// RemoveFromSM is set when the cluster must be removed from system model once an uninstall finishes.
+	RemoveFromSM bool `json:"remove_from_sm,omitempty"`
+	// Platform contains the target platform of a provision so that the cluster is installed on the same one.
+	Platform grpc_installer_go.Platform `json:"platform,omitempty"`
+	// Nodes contains the number of nodes requested by a provision or a scale.
+	Nodes int64 `json:"nodes,omitempty"`
+	// Drained contains the nodes cordoned and drained before a scale down, so that the ones that are not removed
+	// are uncordoned once the scale finishes.
+	Drained []string `json:"drained,omitempty"`
+	// Draining is set while the nodes of a scale down are drained, before the scale is sent to the provisioner. The
+	// request of the scale is not stored, so scales interrupted while draining are undone when they are resumed.
+	Draining bool `json:"draining,omitempty"`
+	// RemoveNodes contains the nodes to be removed by a remove nodes operation and the result of the ones processed.
+	RemoveNodes *NodeRemoval `json:"remove_nodes,omitempty"`
+}
+
+// NodeRemoval contains the nodes removed by an operation.
+type NodeRemoval struct {
+	Nodes []string `json:"nodes"`
+	// DeleteKubernetesNode is set if the Kubernetes node objects are deleted.
+	DeleteKubernetesNode bool `json:"delete_kubernetes_node,omitempty"`
+	// Results contains the outcome of the nodes already processed.
+	Results []NodeResult `json:"results,omitempty"`
+}
+
+// NodeResult contains the outcome of removing a node. The error is empty if the node was removed.
+type NodeResult struct {
+	NodeID string `json:"node_id"`
+	Error  string `json:"error,omitempty"`
+}
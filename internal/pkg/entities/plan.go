@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	"strings"
+)
+
+// OperationPlan describes what an operation would do on a cluster without performing it.
+type OperationPlan struct {
+	Type           OperationType `json:"type"`
+	RequestID      string        `json:"request_id"`
+	OrganizationID string        `json:"organization_id"`
+	ClusterID      string        `json:"cluster_id"`
+	ClusterState   string        `json:"cluster_state,omitempty"`
+	// Steps contains the actions that the operation would perform, in order.
+	Steps []string `json:"steps"`
+	// Nodes contains the nodes affected by the operation.
+	Nodes []string `json:"nodes,omitempty"`
+	// Services contains the application services affected by the operation.
+	Services []string `json:"services,omitempty"`
+	// Issues contains the reasons why the operation would be rejected.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// NewOperationPlan creates an empty plan for an operation.
+func NewOperationPlan(operation OperationType, requestID string, organizationID string, clusterID string) *OperationPlan {
+	return &OperationPlan{
+		Type:           operation,
+		RequestID:      requestID,
+		OrganizationID: organizationID,
+		ClusterID:      clusterID,
+		Steps:          make([]string, 0),
+		Nodes:          make([]string, 0),
+		Services:       make([]string, 0),
+		Issues:         make([]string, 0),
+	}
+}
+
+// AddStep appends an action to the plan.
+func (op *OperationPlan) AddStep(step string) {
+	op.Steps = append(op.Steps, step)
+}
+
+// AddIssue records an error that would make the operation fail. Nil errors are ignored.
+func (op *OperationPlan) AddIssue(err derrors.Error) {
+	if err != nil {
+		op.Issues = append(op.Issues, err.Error())
+	}
+}
+
+// Blocked checks if the operation would be rejected.
+func (op *OperationPlan) Blocked() bool {
+	return len(op.Issues) > 0
+}
+
+// Summary returns the issues of the plan as a single message.
+func (op *OperationPlan) Summary() string {
+	return strings.Join(op.Issues, "; ")
+}
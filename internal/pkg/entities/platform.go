@@ -0,0 +1,146 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The target platforms define the validation of the requests sent to the provisioner for each type of
+// infrastructure, so that new platforms can go through the same provision, install and scale workflow.
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+)
+
+// platformRules contains the platform specific checks of each request. A nil check means that the platform
+// does not support the operation.
+type platformRules struct {
+	provision    func(request *grpc_provisioner_go.ProvisionClusterRequest) derrors.Error
+	scale        func(request *grpc_provisioner_go.ScaleClusterRequest) derrors.Error
+	decommission func(request *grpc_provisioner_go.DecommissionClusterRequest) derrors.Error
+}
+
+// azureRequired checks that the Azure credentials and options are present.
+func azureRequired(credentials *grpc_provisioner_go.AzureCredentials, options *grpc_provisioner_go.AzureProvisioningOptions, requireResourceGroup bool) derrors.Error {
+	if credentials == nil {
+		return derrors.NewInvalidArgumentError("azure_credentials must be set when type is Azure")
+	}
+	if options == nil {
+		return derrors.NewInvalidArgumentError("azure_options must be set when type is Azure")
+	}
+	if requireResourceGroup && options.ResourceGroup == "" {
+		return derrors.NewInvalidArgumentError("azure_options.resource_group cannot be empty")
+	}
+	return nil
+}
+
+// azureForbidden checks that the Azure credentials and options are not sent to other platforms.
+func azureForbidden(platform grpc_installer_go.Platform, credentials *grpc_provisioner_go.AzureCredentials, options *grpc_provisioner_go.AzureProvisioningOptions) derrors.Error {
+	if credentials != nil || options != nil {
+		return derrors.NewInvalidArgumentError("azure_credentials and azure_options can only be set when type is Azure").
+			WithParams(platform.String())
+	}
+	return nil
+}
+
+// targetPlatforms contains the rules of each supported platform.
+var targetPlatforms = map[grpc_installer_go.Platform]platformRules{
+	grpc_installer_go.Platform_AZURE: {
+		provision: func(request *grpc_provisioner_go.ProvisionClusterRequest) derrors.Error {
+			return azureRequired(request.AzureCredentials, request.AzureOptions, false)
+		},
+		scale: func(request *grpc_provisioner_go.ScaleClusterRequest) derrors.Error {
+			return azureRequired(request.AzureCredentials, request.AzureOptions, true)
+		},
+		decommission: func(request *grpc_provisioner_go.DecommissionClusterRequest) derrors.Error {
+			return azureRequired(request.AzureCredentials, request.AzureOptions, true)
+		},
+	},
+	grpc_installer_go.Platform_BAREMETAL: {
+		provision: func(request *grpc_provisioner_go.ProvisionClusterRequest) derrors.Error {
+			return azureForbidden(request.TargetPlatform, request.AzureCredentials, request.AzureOptions)
+		},
+		scale: func(request *grpc_provisioner_go.ScaleClusterRequest) derrors.Error {
+			return azureForbidden(request.TargetPlatform, request.AzureCredentials, request.AzureOptions)
+		},
+		decommission: func(request *grpc_provisioner_go.DecommissionClusterRequest) derrors.Error {
+			return azureForbidden(request.TargetPlatform, request.AzureCredentials, request.AzureOptions)
+		},
+	},
+	// Minikube clusters are used for development and run on a single node, so they cannot be scaled.
+	grpc_installer_go.Platform_MINIKUBE: {
+		provision: func(request *grpc_provisioner_go.ProvisionClusterRequest) derrors.Error {
+			if request.NumNodes != 1 {
+				return derrors.NewInvalidArgumentError("minikube clusters must have a single node").WithParams(request.NumNodes)
+			}
+			return azureForbidden(request.TargetPlatform, request.AzureCredentials, request.AzureOptions)
+		},
+		decommission: func(request *grpc_provisioner_go.DecommissionClusterRequest) derrors.Error {
+			return azureForbidden(request.TargetPlatform, request.AzureCredentials, request.AzureOptions)
+		},
+	},
+}
+
+// getPlatformRules retrieves the rules of a platform.
+func getPlatformRules(platform grpc_installer_go.Platform) (*platformRules, derrors.Error) {
+	rules, exists := targetPlatforms[platform]
+	if !exists {
+		return nil, derrors.NewInvalidArgumentError("unsupported target platform").WithParams(platform.String())
+	}
+	return &rules, nil
+}
+
+// ValidPlatform checks that a target platform is supported.
+func ValidPlatform(platform grpc_installer_go.Platform) derrors.Error {
+	_, err := getPlatformRules(platform)
+	return err
+}
+
+// validPlatformProvision applies the platform specific checks of a provision request.
+func validPlatformProvision(request *grpc_provisioner_go.ProvisionClusterRequest) derrors.Error {
+	rules, err := getPlatformRules(request.TargetPlatform)
+	if err != nil {
+		return err
+	}
+	if rules.provision == nil {
+		return derrors.NewInvalidArgumentError("platform does not support provisioning").WithParams(request.TargetPlatform.String())
+	}
+	return rules.provision(request)
+}
+
+// validPlatformScale applies the platform specific checks of a scale request.
+func validPlatformScale(request *grpc_provisioner_go.ScaleClusterRequest) derrors.Error {
+	rules, err := getPlatformRules(request.TargetPlatform)
+	if err != nil {
+		return err
+	}
+	if rules.scale == nil {
+		return derrors.NewInvalidArgumentError("platform does not support scaling").WithParams(request.TargetPlatform.String())
+	}
+	return rules.scale(request)
+}
+
+// validPlatformDecommission applies the platform specific checks of a decommission request.
+func validPlatformDecommission(request *grpc_provisioner_go.DecommissionClusterRequest) derrors.Error {
+	rules, err := getPlatformRules(request.TargetPlatform)
+	if err != nil {
+		return err
+	}
+	if rules.decommission == nil {
+		return derrors.NewInvalidArgumentError("platform does not support decommissioning").WithParams(request.TargetPlatform.String())
+	}
+	return rules.decommission(request)
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Target platforms", func() {
+
+	provisionRequest := func(platform grpc_installer_go.Platform, numNodes int64) *grpc_provisioner_go.ProvisionClusterRequest {
+		return &grpc_provisioner_go.ProvisionClusterRequest{
+			RequestId:      "request",
+			OrganizationId: "org",
+			NumNodes:       numNodes,
+			NodeType:       "type",
+			TargetPlatform: platform,
+		}
+	}
+
+	ginkgo.It("should require the Azure credentials on Azure", func() {
+		request := provisionRequest(grpc_installer_go.Platform_AZURE, 3)
+		gomega.Expect(ValidProvisionClusterRequest(request)).ToNot(gomega.Succeed())
+		request.AzureCredentials = &grpc_provisioner_go.AzureCredentials{}
+		request.AzureOptions = &grpc_provisioner_go.AzureProvisioningOptions{}
+		gomega.Expect(ValidProvisionClusterRequest(request)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject the Azure credentials on other platforms", func() {
+		request := provisionRequest(grpc_installer_go.Platform_BAREMETAL, 3)
+		gomega.Expect(ValidProvisionClusterRequest(request)).To(gomega.Succeed())
+		request.AzureCredentials = &grpc_provisioner_go.AzureCredentials{}
+		gomega.Expect(ValidProvisionClusterRequest(request)).ToNot(gomega.Succeed())
+	})
+
+	ginkgo.It("should only provision single node minikube clusters that cannot be scaled", func() {
+		gomega.Expect(ValidProvisionClusterRequest(provisionRequest(grpc_installer_go.Platform_MINIKUBE, 3))).ToNot(gomega.Succeed())
+		gomega.Expect(ValidProvisionClusterRequest(provisionRequest(grpc_installer_go.Platform_MINIKUBE, 1))).To(gomega.Succeed())
+		scaleRequest := &grpc_provisioner_go.ScaleClusterRequest{
+			OrganizationId: "org",
+			ClusterId:      "cluster",
+			TargetPlatform: grpc_installer_go.Platform_MINIKUBE,
+		}
+		gomega.Expect(ValidScaleClusterRequest(scaleRequest)).ToNot(gomega.Succeed())
+	})
+})
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"k8s.io/api/core/v1"
+)
+
+// ClusterUsage contains the capacity of the schedulable nodes of a cluster and the resources requested by its pods.
+// CPU values are expressed in millicores and memory values in bytes.
+type ClusterUsage struct {
+	// Nodes is the number of nodes of the cluster, whatever their state.
+	Nodes int
+	// SchedulableNodes is the number of ready and schedulable nodes, whose capacity is the allocatable one.
+	SchedulableNodes  int
+	AllocatableCPU    int64
+	AllocatableMemory int64
+	RequestedCPU      int64
+	RequestedMemory   int64
+	// PendingPods is the number of pods that cannot be scheduled due to lack of resources.
+	PendingPods int
+}
+
+// NewClusterUsage computes the usage of a cluster from its nodes and pods. Only the ready nodes that accept new pods
+// provide capacity, and finished pods do not request resources.
+func NewClusterUsage(nodes []v1.Node, pods []v1.Pod) *ClusterUsage {
+	usage := &ClusterUsage{Nodes: len(nodes)}
+	schedulable := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+		schedulable[node.Name] = true
+		usage.SchedulableNodes++
+		usage.AllocatableCPU += node.Status.Allocatable.Cpu().MilliValue()
+		usage.AllocatableMemory += node.Status.Allocatable.Memory().Value()
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if pod.Spec.NodeName == "" {
+			if podUnschedulable(pod) {
+				usage.PendingPods++
+			}
+			continue
+		}
+		if !schedulable[pod.Spec.NodeName] {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			usage.RequestedCPU += container.Resources.Requests.Cpu().MilliValue()
+			usage.RequestedMemory += container.Resources.Requests.Memory().Value()
+		}
+	}
+	return usage
+}
+
+// Utilization returns the highest ratio between the requested and the allocatable resources.
+func (cu *ClusterUsage) Utilization() float64 {
+	result := 0.0
+	if cu.AllocatableCPU > 0 {
+		result = float64(cu.RequestedCPU) / float64(cu.AllocatableCPU)
+	}
+	if cu.AllocatableMemory > 0 {
+		memory := float64(cu.RequestedMemory) / float64(cu.AllocatableMemory)
+		if memory > result {
+			result = memory
+		}
+	}
+	return result
+}
+
+// nodeReady checks if the kubelet of a node reports it as ready.
+func nodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podUnschedulable checks if the scheduler could not find a node for a pod.
+func podUnschedulable(pod v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled {
+			return condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
@@ -26,7 +26,6 @@ import (
 	"strings"
 )
 
-const emptyRequestId = "request_id cannot be empty"
 const emptyOrganizationId = "organization_id cannot be empty"
 const emptyClusterId = "cluster_id cannot be empty"
 const emptyNodeId = "node_id cannot be empty"
@@ -62,6 +61,10 @@ func ValidInstallRequest(installRequest *grpc_installer_go.InstallRequest) derro
 	if installRequest.RequestId != "" {
 		return derrors.NewInvalidArgumentError("request_id must be nil, and set by this component")
 	}
+	pErr := ValidPlatform(installRequest.TargetPlatform)
+	if pErr != nil {
+		return pErr
+	}
 
 	authFound := false
 
@@ -95,7 +98,13 @@ func ValidInstallRequest(installRequest *grpc_installer_go.InstallRequest) derro
 
 // ValidRemoveClusterRequest checks that a Cluster is specified.
 func ValidRemoveClusterRequest(removeClusterRequest *grpc_infrastructure_go.RemoveClusterRequest) derrors.Error {
-	return derrors.NewUnimplementedError("ValidRemoveClusterRequest")
+	if removeClusterRequest.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if removeClusterRequest.ClusterId == "" {
+		return derrors.NewInvalidArgumentError(emptyClusterId)
+	}
+	return nil
 }
 
 // ValidProvisionClusterRequest validates the request to create a new cluster.
@@ -115,13 +124,7 @@ func ValidProvisionClusterRequest(request *grpc_provisioner_go.ProvisionClusterR
 	if request.NodeType == "" {
 		return derrors.NewInvalidArgumentError("node_type must be set")
 	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && request.AzureCredentials == nil {
-		return derrors.NewInvalidArgumentError("azure_credentials must be set when type is Azure")
-	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && request.AzureOptions == nil {
-		return derrors.NewInvalidArgumentError("azure_options must be set when type is Azure")
-	}
-	return nil
+	return validPlatformProvision(request)
 }
 
 // ValidScaleClusterRequest checks that the scale request contains the required values.
@@ -138,13 +141,7 @@ func ValidScaleClusterRequest(request *grpc_provisioner_go.ScaleClusterRequest)
 	if request.IsManagementCluster {
 		return derrors.NewInvalidArgumentError("can only scale application clusters")
 	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && request.AzureCredentials == nil {
-		return derrors.NewInvalidArgumentError("azure_credentials cannot be empty")
-	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && (request.AzureOptions == nil || request.AzureOptions.ResourceGroup == "") {
-		return derrors.NewInvalidArgumentError("azure_options.resource_group cannot be empty")
-	}
-	return nil
+	return validPlatformScale(request)
 }
 
 // ValidUninstallClusterRequest checks that the uninstall request contains the required values.
@@ -178,20 +175,12 @@ func ValidDecommissionClusterRequest(request *grpc_provisioner_go.DecommissionCl
 	if request.IsManagementCluster {
 		return derrors.NewInvalidArgumentError("can only decommission application clusters")
 	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && request.AzureCredentials == nil {
-		return derrors.NewInvalidArgumentError("azure_credentials cannot be empty")
-	}
-	if request.TargetPlatform == grpc_installer_go.Platform_AZURE && (request.AzureOptions == nil || request.AzureOptions.ResourceGroup == "") {
-		return derrors.NewInvalidArgumentError("azure_options.resource_group cannot be empty")
-	}
-	return nil
+	return validPlatformDecommission(request)
 }
 
-// ValidRemoveNodesRequest checks that the request specifies the organization and the list of nodes.
+// ValidRemoveNodesRequest checks that the request specifies the organization and the list of nodes. The request
+// identifier is set by this component.
 func ValidRemoveNodesRequest(removeNodesRequest *grpc_infrastructure_go.RemoveNodesRequest) derrors.Error {
-	if removeNodesRequest.RequestId == "" {
-		return derrors.NewInvalidArgumentError(emptyRequestId)
-	}
 	if removeNodesRequest.OrganizationId == "" {
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
 	}
@@ -236,7 +225,7 @@ func ValidUpdateNodeRequest(request *grpc_infrastructure_go.UpdateNodeRequest) d
 	if request.OrganizationId == "" {
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
 	}
-	if request.NodeId != "" {
+	if request.NodeId == "" {
 		return derrors.NewInvalidArgumentError(emptyNodeId)
 	}
 	if request.AddLabels {
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// sharedConfigMap provides access to a ConfigMap modified by several replicas.
+type sharedConfigMap struct {
+	client    coreClient.ConfigMapsGetter
+	namespace string
+	name      string
+}
+
+// get retrieves the ConfigMap, creating it if it does not exist.
+func (j *sharedConfigMap) get() (*coreV1.ConfigMap, derrors.Error) {
+	configMap, err := j.client.ConfigMaps(j.namespace).Get(j.name, metaV1.GetOptions{})
+	if err == nil {
+		return configMap, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, derrors.AsError(err, "cannot retrieve configmap").WithParams(j.name)
+	}
+	configMap, err = j.client.ConfigMaps(j.namespace).Create(&coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      j.name,
+			Namespace: j.namespace,
+		},
+		Data: make(map[string]string, 0),
+	})
+	if err != nil {
+		if errors.IsAlreadyExists(err) {
+			// Created by another replica
+			configMap, err = j.client.ConfigMaps(j.namespace).Get(j.name, metaV1.GetOptions{})
+			if err == nil {
+				return configMap, nil
+			}
+		}
+		return nil, derrors.AsError(err, "cannot create configmap").WithParams(j.name)
+	}
+	log.Info().Str("namespace", j.namespace).Str("name", j.name).Msg("configmap created")
+	return configMap, nil
+}
+
+// modify applies a change to the entries of the ConfigMap retrying on conflicts with other writers.
+func (j *sharedConfigMap) modify(change func(data map[string]string)) derrors.Error {
+	var dErr derrors.Error
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, gErr := j.get()
+		if gErr != nil {
+			dErr = gErr
+			return nil
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string, 0)
+		}
+		change(configMap.Data)
+		_, err := j.client.ConfigMaps(j.namespace).Update(configMap)
+		return err
+	})
+	if dErr != nil {
+		return dErr
+	}
+	if err != nil {
+		return derrors.AsError(err, "cannot update configmap").WithParams(j.name)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The journal keeps track of the operations that are being monitored so that they can be resumed after a restart.
+
+package journal
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// journalFileSuffix is the extension of the files that contain a journal entry.
+const journalFileSuffix = ".json"
+
+// Journal defines the operations to persist the ongoing operations of the infrastructure manager.
+type Journal interface {
+	// Put stores an operation replacing any previous entry with the same request identifier.
+	Put(operation entities.Operation) derrors.Error
+	// Get retrieves an operation by its request identifier.
+	Get(requestID string) (*entities.Operation, derrors.Error)
+	// Remove deletes an operation from the journal. Removing a non existing operation is not an error.
+	Remove(requestID string) derrors.Error
+	// List retrieves all the operations stored in the journal.
+	List() ([]entities.Operation, derrors.Error)
+}
+
+// FileJournal is a journal that stores each operation as a JSON file in a given directory.
+type FileJournal struct {
+	sync.Mutex
+	basePath string
+}
+
+// NewFileJournal creates a new journal on the given directory, creating it if required.
+func NewFileJournal(basePath string) (*FileJournal, derrors.Error) {
+	err := os.MkdirAll(basePath, 0700)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create journal directory")
+	}
+	return &FileJournal{basePath: basePath}, nil
+}
+
+// entryPath returns the path of the file associated with a request.
+func (j *FileJournal) entryPath(requestID string) (string, derrors.Error) {
+	if requestID == "" || strings.ContainsAny(requestID, `/\`) || requestID == "." || requestID == ".." {
+		return "", derrors.NewInvalidArgumentError("invalid request_id for journal entry").WithParams(requestID)
+	}
+	return filepath.Join(j.basePath, requestID+journalFileSuffix), nil
+}
+
+// Put stores an operation replacing any previous entry with the same request identifier. The entry is
+// written to a temporal file first so that a crash never leaves a partially written entry.
+func (j *FileJournal) Put(operation entities.Operation) derrors.Error {
+	j.Lock()
+	defer j.Unlock()
+	path, pErr := j.entryPath(operation.RequestID)
+	if pErr != nil {
+		return pErr
+	}
+	content, err := json.Marshal(operation)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal journal entry")
+	}
+	return writeFile(path, content)
+}
+
+// writeFile writes a content to a temporal file in the same directory first, and then renames it, so that a crash
+// never leaves a partially written file.
+func writeFile(path string, content []byte) derrors.Error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return derrors.AsError(err, "cannot create temporal file").WithParams(path)
+	}
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	cErr := tmpFile.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return derrors.AsError(err, "cannot write file").WithParams(path)
+	}
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return derrors.AsError(err, "cannot store file").WithParams(path)
+	}
+	return nil
+}
+
+// Get retrieves an operation by its request identifier.
+func (j *FileJournal) Get(requestID string) (*entities.Operation, derrors.Error) {
+	j.Lock()
+	defer j.Unlock()
+	path, pErr := j.entryPath(requestID)
+	if pErr != nil {
+		return nil, pErr
+	}
+	return j.read(path)
+}
+
+// read loads an operation from a journal file.
+func (j *FileJournal) read(path string) (*entities.Operation, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, derrors.NewNotFoundError("operation not found in journal").WithParams(filepath.Base(path))
+		}
+		return nil, derrors.AsError(err, "cannot read journal entry")
+	}
+	operation := &entities.Operation{}
+	err = json.Unmarshal(content, operation)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot unmarshal journal entry")
+	}
+	return operation, nil
+}
+
+// Remove deletes an operation from the journal. Removing a non existing operation is not an error.
+func (j *FileJournal) Remove(requestID string) derrors.Error {
+	j.Lock()
+	defer j.Unlock()
+	path, pErr := j.entryPath(requestID)
+	if pErr != nil {
+		return pErr
+	}
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return derrors.AsError(err, "cannot remove journal entry")
+	}
+	return nil
+}
+
+// List retrieves all the operations stored in the journal. Entries that cannot be read are skipped.
+func (j *FileJournal) List() ([]entities.Operation, derrors.Error) {
+	j.Lock()
+	defer j.Unlock()
+	files, err := ioutil.ReadDir(j.basePath)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list journal entries")
+	}
+	result := make([]entities.Operation, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), journalFileSuffix) {
+			continue
+		}
+		operation, rErr := j.read(filepath.Join(j.basePath, f.Name()))
+		if rErr != nil {
+			log.Warn().Str("file", f.Name()).Str("trace", rErr.DebugReport()).Msg("skipping invalid journal entry")
+			continue
+		}
+		result = append(result, *operation)
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+var _ = ginkgo.Describe("A file journal", func() {
+
+	var basePath string
+	var journal *FileJournal
+
+	ginkgo.BeforeEach(func() {
+		created, err := ioutil.TempDir("", "journalTest")
+		gomega.Expect(err).To(gomega.Succeed())
+		basePath = created
+		j, jErr := NewFileJournal(basePath)
+		gomega.Expect(jErr).To(gomega.Succeed())
+		journal = j
+	})
+
+	ginkgo.AfterEach(func() {
+		gomega.Expect(os.RemoveAll(basePath)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should store and retrieve an operation", func() {
+		toAdd := entities.Operation{
+			RequestID:      "request",
+			OrganizationID: "organization",
+			ClusterID:      "cluster",
+			Type:           entities.UninstallOperation,
+			Created:        1,
+			Decommission: &grpc_provisioner_go.DecommissionClusterRequest{
+				RequestId: "request",
+				ClusterId: "cluster",
+			},
+		}
+		gomega.Expect(journal.Put(toAdd)).To(gomega.Succeed())
+		retrieved, err := journal.Get(toAdd.RequestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*retrieved).Should(gomega.Equal(toAdd))
+	})
+
+	ginkgo.It("should replace an operation with the same request", func() {
+		toAdd := entities.Operation{RequestID: "request", Type: entities.UninstallOperation}
+		gomega.Expect(journal.Put(toAdd)).To(gomega.Succeed())
+		toAdd.Type = entities.DecommissionOperation
+		gomega.Expect(journal.Put(toAdd)).To(gomega.Succeed())
+		list, err := journal.List()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(1))
+		gomega.Expect(list[0].Type).Should(gomega.Equal(entities.DecommissionOperation))
+	})
+
+	ginkgo.It("should keep the operations when the journal is reopened", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "r1"})).To(gomega.Succeed())
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "r2"})).To(gomega.Succeed())
+		reopened, err := NewFileJournal(basePath)
+		gomega.Expect(err).To(gomega.Succeed())
+		list, err := reopened.List()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should remove an operation", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "request"})).To(gomega.Succeed())
+		gomega.Expect(journal.Remove("request")).To(gomega.Succeed())
+		_, err := journal.Get("request")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		// Removing it twice is not an error
+		gomega.Expect(journal.Remove("request")).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject invalid request identifiers", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "../request"})).ToNot(gomega.Succeed())
+		gomega.Expect(journal.Put(entities.Operation{RequestID: ""})).ToNot(gomega.Succeed())
+	})
+
+})
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeysConfigMapName is the prefix of the names of the ConfigMaps used to share the idempotency keys among the
+// replicas.
+const DefaultKeysConfigMapName = "infrastructure-manager-idempotency-keys"
+
+// keysShards is the number of ConfigMaps the idempotency keys are spread across, so that the keys retained during
+// the retention period do not exceed the size limit of a single ConfigMap.
+const keysShards = 16
+
+// KeyRecord contains the response of a request sent with an idempotency key.
+type KeyRecord struct {
+	Key string `json:"key"`
+	// Digest identifies the payload of the request that used the key.
+	Digest   string          `json:"digest,omitempty"`
+	Response json.RawMessage `json:"response"`
+	// Expires contains the timestamp after which the key is forgotten.
+	Expires int64 `json:"expires"`
+}
+
+// expired returns true if the retention period of the key has expired.
+func (r *KeyRecord) expired() bool {
+	return time.Now().Unix() > r.Expires
+}
+
+// KeyStore persists the responses of the requests sent with an idempotency key so that retries received after a
+// restart, or by a new leader, return the original response.
+type KeyStore interface {
+	// Put stores the response of a key replacing any previous one.
+	Put(record KeyRecord) derrors.Error
+	// Get retrieves the response of a key. A NotFound error is returned if the key does not exist or has expired.
+	Get(key string) (*KeyRecord, derrors.Error)
+	// Purge removes the keys whose retention period has expired.
+	Purge() derrors.Error
+}
+
+// recordName returns the name under which a key is stored. Keys are chosen by the clients so they are hashed to
+// obtain a valid file name or ConfigMap key.
+func recordName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// decodeRecord unmarshals a stored key, returning a NotFound error if it has expired.
+func decodeRecord(key string, content []byte) (*KeyRecord, derrors.Error) {
+	record := &KeyRecord{}
+	err := json.Unmarshal(content, record)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot unmarshal idempotency key")
+	}
+	if record.expired() {
+		return nil, derrors.NewNotFoundError("idempotency key expired").WithParams(key)
+	}
+	return record, nil
+}
+
+// FileKeyStore is a key store that keeps each key as a JSON file in a given directory.
+type FileKeyStore struct {
+	sync.Mutex
+	basePath string
+}
+
+// NewFileKeyStore creates a new key store on the given directory, creating it if required.
+func NewFileKeyStore(basePath string) (*FileKeyStore, derrors.Error) {
+	err := os.MkdirAll(basePath, 0700)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create idempotency keys directory")
+	}
+	return &FileKeyStore{basePath: basePath}, nil
+}
+
+// Put stores the response of a key replacing any previous one.
+func (ks *FileKeyStore) Put(record KeyRecord) derrors.Error {
+	ks.Lock()
+	defer ks.Unlock()
+	content, err := json.Marshal(record)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal idempotency key")
+	}
+	return writeFile(filepath.Join(ks.basePath, recordName(record.Key)+journalFileSuffix), content)
+}
+
+// Get retrieves the response of a key.
+func (ks *FileKeyStore) Get(key string) (*KeyRecord, derrors.Error) {
+	ks.Lock()
+	defer ks.Unlock()
+	content, err := ioutil.ReadFile(filepath.Join(ks.basePath, recordName(key)+journalFileSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, derrors.NewNotFoundError("idempotency key not found").WithParams(key)
+		}
+		return nil, derrors.AsError(err, "cannot read idempotency key")
+	}
+	return decodeRecord(key, content)
+}
+
+// Purge removes the keys whose retention period has expired. Files that cannot be read are removed too.
+func (ks *FileKeyStore) Purge() derrors.Error {
+	ks.Lock()
+	defer ks.Unlock()
+	files, err := ioutil.ReadDir(ks.basePath)
+	if err != nil {
+		return derrors.AsError(err, "cannot list idempotency keys")
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), journalFileSuffix) {
+			continue
+		}
+		path := filepath.Join(ks.basePath, f.Name())
+		content, rErr := ioutil.ReadFile(path)
+		if rErr == nil {
+			_, dErr := decodeRecord(f.Name(), content)
+			if dErr == nil {
+				continue
+			}
+		}
+		rErr = os.Remove(path)
+		if rErr != nil && !os.IsNotExist(rErr) {
+			log.Warn().Str("file", f.Name()).Err(rErr).Msg("cannot remove expired idempotency key")
+		}
+	}
+	return nil
+}
+
+// ConfigMapKeyStore is a key store that keeps the keys as entries of Kubernetes ConfigMaps so that they are shared
+// by several replicas of the infrastructure manager. The keys are spread across several ConfigMaps by their hash,
+// and the expired keys of a ConfigMap are removed each time a key is stored on it. The responses do not contain
+// credentials.
+type ConfigMapKeyStore struct {
+	sync.Mutex
+	shards []sharedConfigMap
+}
+
+// NewConfigMapKeyStore creates a new key store on the ConfigMaps whose names start with the given one, creating them
+// if required.
+func NewConfigMapKeyStore(client coreClient.ConfigMapsGetter, namespace string, name string) (*ConfigMapKeyStore, derrors.Error) {
+	store := &ConfigMapKeyStore{
+		shards: make([]sharedConfigMap, 0, keysShards),
+	}
+	for i := 0; i < keysShards; i++ {
+		shard := sharedConfigMap{
+			client:    client,
+			namespace: namespace,
+			name:      fmt.Sprintf("%s-%x", name, i),
+		}
+		_, err := shard.get()
+		if err != nil {
+			return nil, err
+		}
+		store.shards = append(store.shards, shard)
+	}
+	return store, nil
+}
+
+// shard returns the ConfigMap where a stored key is kept.
+func (ks *ConfigMapKeyStore) shard(name string) *sharedConfigMap {
+	index, _ := strconv.ParseInt(name[:1], 16, 0)
+	return &ks.shards[int(index)%len(ks.shards)]
+}
+
+// Put stores the response of a key replacing any previous one. The expired keys of the same ConfigMap are removed.
+func (ks *ConfigMapKeyStore) Put(record KeyRecord) derrors.Error {
+	ks.Lock()
+	defer ks.Unlock()
+	content, err := json.Marshal(record)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal idempotency key")
+	}
+	name := recordName(record.Key)
+	return ks.shard(name).modify(func(data map[string]string) {
+		removeExpired(data)
+		data[name] = string(content)
+	})
+}
+
+// Get retrieves the response of a key.
+func (ks *ConfigMapKeyStore) Get(key string) (*KeyRecord, derrors.Error) {
+	ks.Lock()
+	defer ks.Unlock()
+	name := recordName(key)
+	configMap, err := ks.shard(name).get()
+	if err != nil {
+		return nil, err
+	}
+	content, exists := configMap.Data[name]
+	if !exists {
+		return nil, derrors.NewNotFoundError("idempotency key not found").WithParams(key)
+	}
+	return decodeRecord(key, []byte(content))
+}
+
+// Purge removes the keys whose retention period has expired, as well as the entries that cannot be read.
+func (ks *ConfigMapKeyStore) Purge() derrors.Error {
+	ks.Lock()
+	defer ks.Unlock()
+	for i := range ks.shards {
+		err := ks.shards[i].modify(removeExpired)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeExpired deletes the expired keys, as well as the entries that cannot be read, from the data of a ConfigMap.
+func removeExpired(data map[string]string) {
+	for name, content := range data {
+		_, err := decodeRecord(name, []byte(content))
+		if err != nil {
+			delete(data, name)
+		}
+	}
+}
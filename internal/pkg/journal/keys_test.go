@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"fmt"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"time"
+)
+
+var _ = ginkgo.Describe("A ConfigMap key store", func() {
+
+	var client *fake.Clientset
+	var store *ConfigMapKeyStore
+
+	ginkgo.BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		created, err := NewConfigMapKeyStore(client.CoreV1(), "nalej", DefaultKeysConfigMapName)
+		gomega.Expect(err).To(gomega.Succeed())
+		store = created
+	})
+
+	ginkgo.It("should store and retrieve a key", func() {
+		record := KeyRecord{
+			Key:      "org/install/key",
+			Digest:   "digest",
+			Response: []byte(`{"request_id":"request"}`),
+			Expires:  time.Now().Add(time.Minute).Unix(),
+		}
+		gomega.Expect(store.Put(record)).To(gomega.Succeed())
+		retrieved, err := store.Get(record.Key)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(retrieved.Digest).Should(gomega.Equal("digest"))
+		gomega.Expect(string(retrieved.Response)).Should(gomega.Equal(string(record.Response)))
+	})
+
+	ginkgo.It("should remove the expired keys of a ConfigMap when a key is stored on it", func() {
+		expired := KeyRecord{Key: "expired", Response: []byte(`{}`), Expires: time.Now().Add(-time.Minute).Unix()}
+		gomega.Expect(store.Put(expired)).To(gomega.Succeed())
+		// Find another key kept in the same ConfigMap.
+		key := ""
+		for i := 0; key == ""; i++ {
+			candidate := fmt.Sprintf("key%d", i)
+			if recordName(candidate)[0] == recordName(expired.Key)[0] {
+				key = candidate
+			}
+		}
+		gomega.Expect(store.Put(KeyRecord{Key: key, Response: []byte(`{}`), Expires: time.Now().Add(time.Minute).Unix()})).To(gomega.Succeed())
+		configMap, err := client.CoreV1().ConfigMaps("nalej").Get(store.shard(recordName(key)).name, metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(configMap.Data)).Should(gomega.Equal(1))
+		_, exists := configMap.Data[recordName(key)]
+		gomega.Expect(exists).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should spread the keys across several ConfigMaps", func() {
+		for i := 0; i < 32; i++ {
+			record := KeyRecord{Key: fmt.Sprintf("key%d", i), Response: []byte(`{}`), Expires: time.Now().Add(time.Minute).Unix()}
+			gomega.Expect(store.Put(record)).To(gomega.Succeed())
+		}
+		list, err := client.CoreV1().ConfigMaps("nalej").List(metaV1.ListOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list.Items)).Should(gomega.Equal(keysShards))
+		used := 0
+		for _, configMap := range list.Items {
+			if len(configMap.Data) > 0 {
+				used++
+			}
+		}
+		gomega.Expect(used).Should(gomega.BeNumerically(">", 1))
+	})
+})
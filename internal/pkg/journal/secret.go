@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strings"
+)
+
+const (
+	// secretPrefix is the prefix of the name of the secrets that contain a journal entry.
+	secretPrefix = "journal-"
+	// secretKey is the entry of the secret that contains the operation.
+	secretKey = "operation"
+	// requestLabel identifies the request of a journal entry.
+	requestLabel = "nalej-journal-request"
+)
+
+// SecretJournal is a journal that stores each operation in a Kubernetes Secret so that it can be shared by several
+// replicas of the infrastructure manager. Secrets are used as the operations contain the credentials of the
+// requests that launched them.
+type SecretJournal struct {
+	client    coreClient.SecretsGetter
+	namespace string
+}
+
+// NewSecretJournal creates a new journal on the given namespace.
+func NewSecretJournal(client coreClient.SecretsGetter, namespace string) *SecretJournal {
+	return &SecretJournal{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// secretName returns the name of the secret associated with a request.
+func (j *SecretJournal) secretName(requestID string) (string, derrors.Error) {
+	name := secretPrefix + strings.ToLower(requestID)
+	if requestID == "" || len(validation.IsDNS1123Subdomain(name)) > 0 || len(validation.IsValidLabelValue(requestID)) > 0 {
+		return "", derrors.NewInvalidArgumentError("invalid request_id for journal entry").WithParams(requestID)
+	}
+	return name, nil
+}
+
+// Put stores an operation replacing any previous entry with the same request identifier.
+func (j *SecretJournal) Put(operation entities.Operation) derrors.Error {
+	name, nErr := j.secretName(operation.RequestID)
+	if nErr != nil {
+		return nErr
+	}
+	content, err := json.Marshal(operation)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal journal entry")
+	}
+	secret := &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: j.namespace,
+			Labels: map[string]string{
+				requestLabel: operation.RequestID,
+			},
+		},
+		Type: coreV1.SecretTypeOpaque,
+		Data: map[string][]byte{secretKey: content},
+	}
+	_, err = j.client.Secrets(j.namespace).Create(secret)
+	if errors.IsAlreadyExists(err) {
+		_, err = j.client.Secrets(j.namespace).Update(secret)
+	}
+	if err != nil {
+		return derrors.AsError(err, "cannot store journal secret")
+	}
+	return nil
+}
+
+// Get retrieves an operation by its request identifier.
+func (j *SecretJournal) Get(requestID string) (*entities.Operation, derrors.Error) {
+	name, nErr := j.secretName(requestID)
+	if nErr != nil {
+		return nil, nErr
+	}
+	secret, err := j.client.Secrets(j.namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, derrors.NewNotFoundError("operation not found in journal").WithParams(requestID)
+		}
+		return nil, derrors.AsError(err, "cannot retrieve journal secret")
+	}
+	if secret.Labels[requestLabel] != requestID {
+		return nil, derrors.NewNotFoundError("operation not found in journal").WithParams(requestID)
+	}
+	return j.read(secret)
+}
+
+// read loads an operation from a journal secret.
+func (j *SecretJournal) read(secret *coreV1.Secret) (*entities.Operation, derrors.Error) {
+	operation := &entities.Operation{}
+	err := json.Unmarshal(secret.Data[secretKey], operation)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot unmarshal journal entry")
+	}
+	return operation, nil
+}
+
+// Remove deletes an operation from the journal. Removing a non existing operation is not an error.
+func (j *SecretJournal) Remove(requestID string) derrors.Error {
+	name, nErr := j.secretName(requestID)
+	if nErr != nil {
+		return nErr
+	}
+	err := j.client.Secrets(j.namespace).Delete(name, &metaV1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return derrors.AsError(err, "cannot remove journal secret")
+	}
+	return nil
+}
+
+// List retrieves all the operations stored in the journal. Entries that cannot be read are skipped.
+func (j *SecretJournal) List() ([]entities.Operation, derrors.Error) {
+	requirement, err := labels.NewRequirement(requestLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot build journal selector")
+	}
+	secrets, err := j.client.Secrets(j.namespace).List(metaV1.ListOptions{
+		LabelSelector: labels.NewSelector().Add(*requirement).String(),
+	})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list journal secrets")
+	}
+	result := make([]entities.Operation, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if !strings.HasPrefix(secret.Name, secretPrefix) {
+			continue
+		}
+		operation, rErr := j.read(&secret)
+		if rErr != nil {
+			log.Warn().Str("secret", secret.Name).Str("trace", rErr.DebugReport()).Msg("skipping invalid journal entry")
+			continue
+		}
+		result = append(result, *operation)
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package journal
+
+import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = ginkgo.Describe("A secret journal", func() {
+
+	var client *fake.Clientset
+	var journal *SecretJournal
+
+	ginkgo.BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		journal = NewSecretJournal(client.CoreV1(), "nalej")
+	})
+
+	ginkgo.It("should store, retrieve and remove an operation", func() {
+		toAdd := entities.Operation{
+			RequestID:      "request",
+			OrganizationID: "organization",
+			ClusterID:      "cluster",
+			Type:           entities.ScaleOperation,
+			Created:        1,
+		}
+		gomega.Expect(journal.Put(toAdd)).To(gomega.Succeed())
+		retrieved, err := journal.Get(toAdd.RequestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*retrieved).Should(gomega.Equal(toAdd))
+		gomega.Expect(journal.Remove(toAdd.RequestID)).To(gomega.Succeed())
+		_, err = journal.Get(toAdd.RequestID)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should share the operations with other replicas", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "r1"})).To(gomega.Succeed())
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "r2"})).To(gomega.Succeed())
+		other := NewSecretJournal(client.CoreV1(), "nalej")
+		list, err := other.List()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should reject invalid request identifiers", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "../request"})).ToNot(gomega.Succeed())
+		gomega.Expect(journal.Put(entities.Operation{RequestID: ""})).ToNot(gomega.Succeed())
+	})
+
+	ginkgo.It("should not mix request identifiers that only differ in case", func() {
+		gomega.Expect(journal.Put(entities.Operation{RequestID: "Request"})).To(gomega.Succeed())
+		_, err := journal.Get("request")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
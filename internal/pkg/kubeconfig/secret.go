@@ -0,0 +1,150 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubeconfig
+
+import (
+	"github.com/nalej/derrors"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strings"
+)
+
+const (
+	// secretPrefix is the prefix of the name of the secrets that contain a kubeconfig.
+	secretPrefix = "kubeconfig-"
+	// secretKey is the entry of the secret that contains the kubeconfig.
+	secretKey = "kubeconfig"
+	// organizationLabel and clusterLabel identify the cluster of a secret.
+	organizationLabel = "nalej-organization"
+	clusterLabel      = "nalej-cluster"
+)
+
+// SecretStore is a store that keeps each kubeconfig in a Kubernetes Secret so that it can be shared by several
+// replicas of the infrastructure manager.
+type SecretStore struct {
+	client    coreClient.SecretsGetter
+	namespace string
+}
+
+// NewSecretStore creates a new store on the given namespace.
+func NewSecretStore(client coreClient.SecretsGetter, namespace string) *SecretStore {
+	return &SecretStore{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// secretName returns the name of the secret associated with a cluster. Cluster identifiers are unique across
+// organizations.
+func (ss *SecretStore) secretName(organizationID string, clusterID string) (string, derrors.Error) {
+	for _, id := range []string{organizationID, clusterID} {
+		vErr := validID(id)
+		if vErr != nil {
+			return "", vErr
+		}
+	}
+	return secretPrefix + strings.ToLower(clusterID), nil
+}
+
+// Put stores the kubeconfig of a cluster replacing any previous one.
+func (ss *SecretStore) Put(organizationID string, clusterID string, kubeConfig string) derrors.Error {
+	name, nErr := ss.secretName(organizationID, clusterID)
+	if nErr != nil {
+		return nErr
+	}
+	secret := &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: ss.namespace,
+			Labels: map[string]string{
+				organizationLabel: organizationID,
+				clusterLabel:      clusterID,
+			},
+		},
+		Type: coreV1.SecretTypeOpaque,
+		Data: map[string][]byte{secretKey: []byte(kubeConfig)},
+	}
+	_, err := ss.client.Secrets(ss.namespace).Create(secret)
+	if errors.IsAlreadyExists(err) {
+		_, err = ss.client.Secrets(ss.namespace).Update(secret)
+	}
+	if err != nil {
+		return derrors.AsError(err, "cannot store kubeconfig secret")
+	}
+	return nil
+}
+
+// Get retrieves the kubeconfig of a cluster.
+func (ss *SecretStore) Get(organizationID string, clusterID string) (string, derrors.Error) {
+	name, nErr := ss.secretName(organizationID, clusterID)
+	if nErr != nil {
+		return "", nErr
+	}
+	secret, err := ss.client.Secrets(ss.namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", derrors.NewNotFoundError("kubeconfig not found").WithParams(organizationID, clusterID)
+		}
+		return "", derrors.AsError(err, "cannot retrieve kubeconfig secret")
+	}
+	if secret.Labels[organizationLabel] != organizationID {
+		return "", derrors.NewNotFoundError("kubeconfig not found").WithParams(organizationID, clusterID)
+	}
+	return string(secret.Data[secretKey]), nil
+}
+
+// Remove deletes the kubeconfig of a cluster. Removing a non existing kubeconfig is not an error.
+func (ss *SecretStore) Remove(organizationID string, clusterID string) derrors.Error {
+	name, nErr := ss.secretName(organizationID, clusterID)
+	if nErr != nil {
+		return nErr
+	}
+	err := ss.client.Secrets(ss.namespace).Delete(name, &metaV1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return derrors.AsError(err, "cannot remove kubeconfig secret")
+	}
+	return nil
+}
+
+// List retrieves the clusters that have a kubeconfig.
+func (ss *SecretStore) List() ([]ClusterRef, derrors.Error) {
+	requirement, err := labels.NewRequirement(clusterLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot build kubeconfig selector")
+	}
+	secrets, err := ss.client.Secrets(ss.namespace).List(metaV1.ListOptions{
+		LabelSelector: labels.NewSelector().Add(*requirement).String(),
+	})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list kubeconfig secrets")
+	}
+	result := make([]ClusterRef, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if !strings.HasPrefix(secret.Name, secretPrefix) {
+			continue
+		}
+		result = append(result, ClusterRef{
+			OrganizationID: secret.Labels[organizationLabel],
+			ClusterID:      secret.Labels[clusterLabel],
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The kubeconfig store keeps the credentials of the clusters imported or provisioned by the platform so that they can
+// be uninstalled, removed and have their nodes managed and reconciled later on.
+
+package kubeconfig
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store defines the operations to persist the kubeconfig of the clusters.
+type Store interface {
+	// Put stores the kubeconfig of a cluster replacing any previous one.
+	Put(organizationID string, clusterID string, kubeConfig string) derrors.Error
+	// Get retrieves the kubeconfig of a cluster.
+	Get(organizationID string, clusterID string) (string, derrors.Error)
+	// Remove deletes the kubeconfig of a cluster. Removing a non existing kubeconfig is not an error.
+	Remove(organizationID string, clusterID string) derrors.Error
+	// List retrieves the clusters that have a kubeconfig.
+	List() ([]ClusterRef, derrors.Error)
+}
+
+// ClusterRef identifies a cluster with a stored kubeconfig.
+type ClusterRef struct {
+	OrganizationID string
+	ClusterID      string
+}
+
+// entrySuffix is the extension of the files that contain a kubeconfig.
+const entrySuffix = ".kubeconfig"
+
+// validID checks that an identifier can be safely used as part of a file or object name.
+func validID(id string) derrors.Error {
+	if id == "" || strings.ContainsAny(id, `/\_`) || id == "." || id == ".." {
+		return derrors.NewInvalidArgumentError("invalid identifier for kubeconfig entry").WithParams(id)
+	}
+	return nil
+}
+
+// FileStore is a store that keeps each kubeconfig as a file in a given directory readable only by its owner.
+type FileStore struct {
+	sync.Mutex
+	basePath string
+}
+
+// NewFileStore creates a new store on the given directory, creating it if required.
+func NewFileStore(basePath string) (*FileStore, derrors.Error) {
+	err := os.MkdirAll(basePath, 0700)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create kubeconfig directory")
+	}
+	return &FileStore{basePath: basePath}, nil
+}
+
+// entryPath returns the path of the file associated with a cluster.
+func (fs *FileStore) entryPath(organizationID string, clusterID string) (string, derrors.Error) {
+	for _, id := range []string{organizationID, clusterID} {
+		vErr := validID(id)
+		if vErr != nil {
+			return "", vErr
+		}
+	}
+	return filepath.Join(fs.basePath, fmt.Sprintf("%s_%s%s", organizationID, clusterID, entrySuffix)), nil
+}
+
+// Put stores the kubeconfig of a cluster replacing any previous one. The kubeconfig is written to a temporal file
+// first so that a crash never leaves a partially written entry.
+func (fs *FileStore) Put(organizationID string, clusterID string, kubeConfig string) derrors.Error {
+	fs.Lock()
+	defer fs.Unlock()
+	path, pErr := fs.entryPath(organizationID, clusterID)
+	if pErr != nil {
+		return pErr
+	}
+	tmpFile, err := ioutil.TempFile(fs.basePath, clusterID)
+	if err != nil {
+		return derrors.AsError(err, "cannot create temporal kubeconfig file")
+	}
+	_, err = tmpFile.WriteString(kubeConfig)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	cErr := tmpFile.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return derrors.AsError(err, "cannot write kubeconfig")
+	}
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return derrors.AsError(err, "cannot store kubeconfig")
+	}
+	return nil
+}
+
+// Get retrieves the kubeconfig of a cluster.
+func (fs *FileStore) Get(organizationID string, clusterID string) (string, derrors.Error) {
+	fs.Lock()
+	defer fs.Unlock()
+	path, pErr := fs.entryPath(organizationID, clusterID)
+	if pErr != nil {
+		return "", pErr
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", derrors.NewNotFoundError("kubeconfig not found").WithParams(organizationID, clusterID)
+		}
+		return "", derrors.AsError(err, "cannot read kubeconfig")
+	}
+	return string(content), nil
+}
+
+// Remove deletes the kubeconfig of a cluster. Removing a non existing kubeconfig is not an error.
+func (fs *FileStore) Remove(organizationID string, clusterID string) derrors.Error {
+	fs.Lock()
+	defer fs.Unlock()
+	path, pErr := fs.entryPath(organizationID, clusterID)
+	if pErr != nil {
+		return pErr
+	}
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return derrors.AsError(err, "cannot remove kubeconfig")
+	}
+	return nil
+}
+
+// List retrieves the clusters that have a kubeconfig. Identifiers cannot contain underscores so the name of each
+// file is split unambiguously.
+func (fs *FileStore) List() ([]ClusterRef, derrors.Error) {
+	fs.Lock()
+	defer fs.Unlock()
+	files, err := ioutil.ReadDir(fs.basePath)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read kubeconfig directory")
+	}
+	result := make([]ClusterRef, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), entrySuffix) {
+			continue
+		}
+		ids := strings.Split(strings.TrimSuffix(file.Name(), entrySuffix), "_")
+		if len(ids) != 2 {
+			continue
+		}
+		result = append(result, ClusterRef{OrganizationID: ids[0], ClusterID: ids[1]})
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubeconfig
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+)
+
+// testStore checks the behaviour shared by all the store implementations.
+func testStore(getStore func() Store) {
+
+	ginkgo.It("should store, retrieve and remove a kubeconfig", func() {
+		store := getStore()
+		gomega.Expect(store.Put("org", "cluster", "config")).To(gomega.Succeed())
+		retrieved, err := store.Get("org", "cluster")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(retrieved).Should(gomega.Equal("config"))
+		gomega.Expect(store.Put("org", "cluster", "updated")).To(gomega.Succeed())
+		retrieved, err = store.Get("org", "cluster")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(retrieved).Should(gomega.Equal("updated"))
+		gomega.Expect(store.Remove("org", "cluster")).To(gomega.Succeed())
+		_, err = store.Get("org", "cluster")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		// Removing it twice is not an error
+		gomega.Expect(store.Remove("org", "cluster")).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not return the kubeconfig of other organizations", func() {
+		store := getStore()
+		gomega.Expect(store.Put("org", "cluster", "config")).To(gomega.Succeed())
+		_, err := store.Get("otherOrg", "cluster")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should list the clusters with a kubeconfig", func() {
+		store := getStore()
+		gomega.Expect(store.Put("org", "cluster1", "config")).To(gomega.Succeed())
+		gomega.Expect(store.Put("org", "cluster2", "config")).To(gomega.Succeed())
+		gomega.Expect(store.Remove("org", "cluster2")).To(gomega.Succeed())
+		clusters, err := store.List()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(clusters).Should(gomega.Equal([]ClusterRef{{OrganizationID: "org", ClusterID: "cluster1"}}))
+	})
+
+	ginkgo.It("should reject invalid identifiers", func() {
+		store := getStore()
+		gomega.Expect(store.Put("org", "../cluster", "config")).ToNot(gomega.Succeed())
+		gomega.Expect(store.Put("", "cluster", "config")).ToNot(gomega.Succeed())
+	})
+}
+
+var _ = ginkgo.Describe("A file kubeconfig store", func() {
+
+	var basePath string
+	var store *FileStore
+
+	ginkgo.BeforeEach(func() {
+		created, err := ioutil.TempDir("", "kubeConfigTest")
+		gomega.Expect(err).To(gomega.Succeed())
+		basePath = created
+		s, sErr := NewFileStore(basePath)
+		gomega.Expect(sErr).To(gomega.Succeed())
+		store = s
+	})
+
+	ginkgo.AfterEach(func() {
+		gomega.Expect(os.RemoveAll(basePath)).To(gomega.Succeed())
+	})
+
+	testStore(func() Store { return store })
+})
+
+var _ = ginkgo.Describe("A secret kubeconfig store", func() {
+
+	var store *SecretStore
+
+	ginkgo.BeforeEach(func() {
+		store = NewSecretStore(fake.NewSimpleClientset().CoreV1(), "nalej")
+	})
+
+	testStore(func() Store { return store })
+})
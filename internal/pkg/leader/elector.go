@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The leader election guarantees that only one replica of the infrastructure manager runs the monitors of the
+// ongoing operations.
+
+package leader
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"time"
+)
+
+const (
+	// DefaultLeaseName is the name of the Lease used to elect the leader.
+	DefaultLeaseName = "infrastructure-manager"
+	// DefaultLeaseDuration is the time the followers wait before trying to acquire a lease that is not renewed.
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is the time the leader keeps trying to renew the lease before giving up the leadership.
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is the time between attempts to acquire or renew the lease.
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// Config contains the settings of the leader election.
+type Config struct {
+	// Namespace where the Lease is created.
+	Namespace string
+	// LeaseName is the name of the Lease.
+	LeaseName string
+	// Identity is the unique identity of this replica. It must be the name of the pod, which is labeled with
+	// LeaderLabel while this replica is the leader.
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Validate checks that the election settings are consistent.
+func (c *Config) Validate() derrors.Error {
+	if c.Namespace == "" {
+		return derrors.NewInvalidArgumentError("leader election namespace must be set")
+	}
+	if c.LeaseName == "" {
+		return derrors.NewInvalidArgumentError("leader election lease name must be set")
+	}
+	if c.Identity == "" {
+		return derrors.NewInvalidArgumentError("leader election identity must be set")
+	}
+	if c.RetryPeriod <= 0 || c.RenewDeadline <= c.RetryPeriod || c.LeaseDuration <= c.RenewDeadline {
+		return derrors.NewInvalidArgumentError("leader election requires leaseDuration > renewDeadline > retryPeriod > 0")
+	}
+	return nil
+}
+
+// Callbacks are invoked when this replica gains or loses the leadership.
+type Callbacks struct {
+	// OnStartedLeading is called once this replica becomes the leader.
+	OnStartedLeading func()
+	// OnStoppedLeading is called once this replica stops being the leader. A replica that loses the leadership is
+	// not expected to become leader again without restarting.
+	OnStoppedLeading func()
+}
+
+// Elector participates in the election of the leader among the replicas of the infrastructure manager.
+type Elector struct {
+	elector *leaderelection.LeaderElector
+	pods    coreClient.PodsGetter
+	config  Config
+}
+
+// NewElector creates an elector that uses a Lease in the given cluster.
+func NewElector(client kubernetes.Interface, config Config, callbacks Callbacks) (*Elector, derrors.Error) {
+	vErr := config.Validate()
+	if vErr != nil {
+		return nil, vErr
+	}
+	lock := &LeaseLock{
+		LeaseMeta: metaV1.ObjectMeta{
+			Namespace: config.Namespace,
+			Name:      config.LeaseName,
+		},
+		Client:       client.CoordinationV1beta1(),
+		LockIdentity: config.Identity,
+	}
+	result := &Elector{
+		pods:   client.CoreV1(),
+		config: config,
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				log.Info().Str("identity", config.Identity).Msg("leadership acquired")
+				result.label(true)
+				callbacks.OnStartedLeading()
+			},
+			OnStoppedLeading: func() {
+				log.Warn().Str("identity", config.Identity).Msg("leadership lost")
+				result.label(false)
+				callbacks.OnStoppedLeading()
+			},
+			OnNewLeader: func(identity string) {
+				log.Info().Str("leader", identity).Msg("new leader elected")
+			},
+		},
+	})
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create leader elector")
+	}
+	result.elector = elector
+	return result, nil
+}
+
+// label updates the leader label of the pod of this replica. Failures are only logged as the requests that reach a
+// follower are rejected anyway.
+func (e *Elector) label(leader bool) {
+	err := SetLeaderLabel(e.pods, e.config.Namespace, e.config.Identity, leader)
+	if err != nil {
+		log.Error().Str("trace", err.DebugReport()).Bool("leader", leader).Msg("cannot label the pod of this replica")
+	}
+}
+
+// Run participates in the election until the context is canceled or the leadership is lost. The leader label is
+// cleared first as it is kept when the container is restarted.
+func (e *Elector) Run(ctx context.Context) {
+	e.label(false)
+	e.elector.Run(ctx)
+}
+
+// IsLeader returns true if this replica is the current leader.
+func (e *Elector) IsLeader() bool {
+	return e.elector.IsLeader()
+}
+
+// GetLeader returns the identity of the current leader.
+func (e *Elector) GetLeader() string {
+	return e.elector.GetLeader()
+}
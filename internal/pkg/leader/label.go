@@ -0,0 +1,46 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package leader
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"k8s.io/apimachinery/pkg/types"
+	coreClient "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strconv"
+)
+
+// LeaderLabel is the label of the pod of the current leader. The service of the infrastructure manager selects it so
+// that the mutating requests are only routed to the leader.
+const LeaderLabel = "infrastructure-manager-leader"
+
+// SetLeaderLabel updates the leader label of a pod.
+func SetLeaderLabel(client coreClient.PodsGetter, namespace string, podName string, leader bool) derrors.Error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{LeaderLabel: strconv.FormatBool(leader)},
+		},
+	})
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal leader label patch")
+	}
+	_, err = client.Pods(namespace).Patch(podName, types.MergePatchType, patch)
+	if err != nil {
+		return derrors.AsError(err, "cannot update leader label").WithParams(podName)
+	}
+	return nil
+}
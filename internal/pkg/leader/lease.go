@@ -0,0 +1,136 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package leader
+
+import (
+	"errors"
+	"github.com/rs/zerolog/log"
+	coordinationV1beta1 "k8s.io/api/coordination/v1beta1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationClient "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"time"
+)
+
+// errLeaseNotInitialized is returned when the lease is updated before being retrieved or created.
+var errLeaseNotInitialized = errors.New("lease not initialized, get or create it first")
+
+// LeaseLock is a resource lock backed by a coordination.k8s.io Lease object. The vendored client-go only provides
+// locks on endpoints and configmaps, so the lease is adapted to the resourcelock interface here.
+type LeaseLock struct {
+	LeaseMeta metaV1.ObjectMeta
+	Client    coordinationClient.LeasesGetter
+	// LockIdentity is the identity of this candidate, usually the name of the pod.
+	LockIdentity string
+	lease        *coordinationV1beta1.Lease
+}
+
+// Get returns the election record stored in the lease.
+func (ll *LeaseLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Get(ll.LeaseMeta.Name, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ll.lease = lease
+	return leaseSpecToRecord(&lease.Spec), nil
+}
+
+// Create creates the lease with the given election record.
+func (ll *LeaseLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Create(&coordinationV1beta1.Lease{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      ll.LeaseMeta.Name,
+			Namespace: ll.LeaseMeta.Namespace,
+		},
+		Spec: recordToLeaseSpec(&ler),
+	})
+	if err != nil {
+		return err
+	}
+	ll.lease = lease
+	return nil
+}
+
+// Update stores the election record in the lease. The lease must have been retrieved or created before.
+func (ll *LeaseLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if ll.lease == nil {
+		return errLeaseNotInitialized
+	}
+	ll.lease.Spec = recordToLeaseSpec(&ler)
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Update(ll.lease)
+	if err != nil {
+		return err
+	}
+	ll.lease = lease
+	return nil
+}
+
+// RecordEvent logs the changes of leadership.
+func (ll *LeaseLock) RecordEvent(message string) {
+	log.Info().Str("lease", ll.Describe()).Str("identity", ll.LockIdentity).Msg(message)
+}
+
+// Describe returns the namespace and name of the lease.
+func (ll *LeaseLock) Describe() string {
+	return ll.LeaseMeta.Namespace + "/" + ll.LeaseMeta.Name
+}
+
+// Identity returns the identity of this candidate.
+func (ll *LeaseLock) Identity() string {
+	return ll.LockIdentity
+}
+
+// leaseSpecToRecord converts the spec of a lease into an election record.
+func leaseSpecToRecord(spec *coordinationV1beta1.LeaseSpec) *resourcelock.LeaderElectionRecord {
+	record := &resourcelock.LeaderElectionRecord{}
+	if spec.HolderIdentity != nil {
+		record.HolderIdentity = *spec.HolderIdentity
+	}
+	if spec.LeaseDurationSeconds != nil {
+		record.LeaseDurationSeconds = int(*spec.LeaseDurationSeconds)
+	}
+	if spec.LeaseTransitions != nil {
+		record.LeaderTransitions = int(*spec.LeaseTransitions)
+	}
+	if spec.AcquireTime != nil {
+		record.AcquireTime = metaV1.NewTime(spec.AcquireTime.Time)
+	}
+	if spec.RenewTime != nil {
+		record.RenewTime = metaV1.NewTime(spec.RenewTime.Time)
+	}
+	return record
+}
+
+// recordToLeaseSpec converts an election record into the spec of a lease.
+func recordToLeaseSpec(record *resourcelock.LeaderElectionRecord) coordinationV1beta1.LeaseSpec {
+	holder := record.HolderIdentity
+	duration := int32(record.LeaseDurationSeconds)
+	transitions := int32(record.LeaderTransitions)
+	return coordinationV1beta1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &duration,
+		AcquireTime:          microTime(record.AcquireTime.Time),
+		RenewTime:            microTime(record.RenewTime.Time),
+		LeaseTransitions:     &transitions,
+	}
+}
+
+// microTime returns the MicroTime representation of a timestamp.
+func microTime(t time.Time) *metaV1.MicroTime {
+	result := metaV1.NewMicroTime(t)
+	return &result
+}
@@ -23,7 +23,6 @@ import (
 	"github.com/nalej/grpc-provisioner-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
-	"time"
 )
 
 // DecommissionerMonitor structure with the required clients to read and update states of a decommission.
@@ -32,6 +31,8 @@ type DecommissionerMonitor struct {
 	clusterId            string
 	requestId            string
 	callback             func(string, *grpc_common_go.OpResponse, derrors.Error)
+	events               <-chan struct{}
+	*stopper
 }
 
 // NewDecommissionerMonitor creates a new monitor with a set of clients.
@@ -44,6 +45,7 @@ func NewDecommissionerMonitor(
 		clusterId:            clusterId,
 		requestId:            requestId,
 		callback:             nil,
+		stopper:              newStopper(),
 	}
 }
 
@@ -53,6 +55,11 @@ func (m *DecommissionerMonitor) RegisterCallback(callback func(clusterID string,
 	m.callback = callback
 }
 
+// RegisterProgressEvents registers a channel that notifies the reception of progress events for the operation.
+func (m *DecommissionerMonitor) RegisterProgressEvents(events <-chan struct{}) {
+	m.events = events
+}
+
 // LaunchMonitor periodically monitors the state of a decommission waiting for it to complete.
 func (m *DecommissionerMonitor) LaunchMonitor() {
 	log.Debug().Str("clusterID", m.clusterId).
@@ -62,7 +69,7 @@ func (m *DecommissionerMonitor) LaunchMonitor() {
 		RequestId: m.requestId,
 	}
 	exit := false
-	remainingFailures := MaxConnFailures
+	remainingFailures := m.policy.MaxFailures
 	var status *grpc_common_go.OpResponse
 	var err error
 	for !exit {
@@ -74,17 +81,21 @@ func (m *DecommissionerMonitor) LaunchMonitor() {
 				log.Warn().Str("requestID", requestID.RequestId).Msg("Cannot contact provisioner")
 				exit = true
 			} else {
-				time.Sleep(ConnectRetryDelay)
+				exit = !m.waitForRetry()
 			}
 		} else {
 			log.Debug().Str("requestID", requestID.RequestId).Int64("elapsed", status.ElapsedTime).Str("state", status.Status.String()).Msg("processing decommission progress")
 			if status.Error != "" || status.Status == grpc_common_go.OpStatus_FAILED || status.Status == grpc_common_go.OpStatus_CANCELED || status.Status == grpc_common_go.OpStatus_SUCCESS {
 				exit = true
 			} else {
-				time.Sleep(QueryDelay)
+				exit = !m.waitForProgress(m.events)
 			}
 		}
 	}
+	if !m.finish() {
+		log.Info().Str("requestID", requestID.RequestId).Msg("Decommission monitor suspended")
+		return
+	}
 	m.notify(status, err)
 	log.Debug().Str("clusterID", m.clusterId).
 		Str("requestID", m.requestId).Msg("Decommission monitor exits")
@@ -93,7 +104,7 @@ func (m *DecommissionerMonitor) LaunchMonitor() {
 // notify informs the associated callback that the installation has finished.
 func (m *DecommissionerMonitor) notify(lastResponse *grpc_common_go.OpResponse, err error) {
 	requestID := &grpc_common_go.RequestId{
-		RequestId: lastResponse.GetRequestId(),
+		RequestId: m.requestId,
 	}
 	_, rErr := m.decommissionerClient.RemoveDecommission(context.Background(), requestID)
 	if rErr != nil {
@@ -101,7 +112,9 @@ func (m *DecommissionerMonitor) notify(lastResponse *grpc_common_go.OpResponse,
 			Str("err", conversions.ToDerror(rErr).DebugReport()).Msg("Cannot remove decommission from provisioner")
 	}
 	var cErr derrors.Error
-	if err != nil {
+	if m.Expired() {
+		cErr = m.deadlineError()
+	} else if err != nil {
 		cErr = conversions.ToDerror(err)
 	}
 	if m.callback != nil {
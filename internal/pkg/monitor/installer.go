@@ -25,7 +25,6 @@ import (
 	"github.com/nalej/grpc-provisioner-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
-	"time"
 )
 
 // InstallerMonitor structure with the required clients to read and update states of an install.
@@ -36,6 +35,8 @@ type InstallerMonitor struct {
 	installerResponse    grpc_common_go.OpResponse
 	callback             func(string, string, string, *grpc_common_go.OpResponse, derrors.Error)
 	decommissionCallback *DecommissionCallback
+	events               <-chan struct{}
+	*stopper
 }
 
 // DecommissionCallback is a structure to handle the callback function and required parameters to execute it
@@ -56,6 +57,7 @@ func NewInstallerMonitor(
 		clusterClient:     clusterClient,
 		installerResponse: installerResponse,
 		callback:          nil,
+		stopper:           newStopper(),
 	}
 }
 
@@ -69,6 +71,11 @@ func (m *InstallerMonitor) RegisterDecommissionCallback(callback *DecommissionCa
 	m.decommissionCallback = callback
 }
 
+// RegisterProgressEvents registers a channel that notifies the reception of progress events for the operation.
+func (m *InstallerMonitor) RegisterProgressEvents(events <-chan struct{}) {
+	m.events = events
+}
+
 // LaunchMonitor periodically monitors the state of an install waiting for it to complete.
 func (m *InstallerMonitor) LaunchMonitor() {
 	log.Debug().Str("requestID", m.installerResponse.RequestId).
@@ -79,7 +86,7 @@ func (m *InstallerMonitor) LaunchMonitor() {
 		RequestId: m.installerResponse.RequestId,
 	}
 	exit := false
-	remainingFailures := MaxConnFailures
+	remainingFailures := m.policy.MaxFailures
 	var response *grpc_common_go.OpResponse
 	var err error
 	for !exit {
@@ -91,26 +98,30 @@ func (m *InstallerMonitor) LaunchMonitor() {
 				log.Warn().Str("requestID", requestID.RequestId).Msg("Cannot contact installer")
 				exit = true
 			} else {
-				time.Sleep(ConnectRetryDelay)
+				exit = !m.waitForRetry()
 			}
 		} else {
 			log.Debug().Str("requestID", response.RequestId).Int64("elapsed", response.ElapsedTime).Msg("processing operation progress")
 			if response.Error != "" || response.Status == grpc_common_go.OpStatus_FAILED || response.Status == grpc_common_go.OpStatus_SUCCESS {
 				exit = true
 			} else {
-				time.Sleep(QueryDelay)
+				exit = !m.waitForProgress(m.events)
 			}
 		}
 	}
+	if !m.finish() {
+		log.Info().Str("requestID", requestID.RequestId).Msg("Installer monitor suspended")
+		return
+	}
 	m.notify(response, err)
-	log.Debug().Str("requestID", response.RequestId).Str("organizationID", response.OrganizationId).
+	log.Debug().Str("requestID", requestID.RequestId).Str("organizationID", m.installerResponse.OrganizationId).
 		Str("clusterID", m.clusterID).Msg("Installer monitor exits")
 }
 
 // notify informs the associated callback that the installation has finished.
 func (m *InstallerMonitor) notify(lastResponse *grpc_common_go.OpResponse, err error) {
 	removeInstallRequest := &grpc_common_go.RequestId{
-		RequestId: lastResponse.RequestId,
+		RequestId: m.installerResponse.RequestId,
 	}
 	_, rErr := m.installerClient.RemoveInstall(context.Background(), removeInstallRequest)
 	if rErr != nil {
@@ -118,7 +129,9 @@ func (m *InstallerMonitor) notify(lastResponse *grpc_common_go.OpResponse, err e
 			Str("err", conversions.ToDerror(rErr).DebugReport()).Msg("Cannot remove operation from installer")
 	}
 	var cErr derrors.Error
-	if err != nil {
+	if m.Expired() {
+		cErr = m.deadlineError()
+	} else if err != nil {
 		cErr = conversions.ToDerror(err)
 	}
 	if m.callback != nil {
@@ -17,17 +17,152 @@
 package monitor
 
 import (
+	"github.com/nalej/derrors"
+	"sync"
 	"time"
 )
 
-// MaxConnFailures contains the number of communication failures allowed until the monitor exists.
+// MaxConnFailures contains the default number of communication failures allowed until the monitor exists.
 const (
 	MaxConnFailures = 5
 	DefaultTimeout  = 2 * time.Minute
 )
 
-// QueryDelay contains the polling interval to check the progress on the provisioner.
+// QueryDelay contains the default polling interval to check the progress on the provisioner.
 const QueryDelay = time.Second * 15
 
-// ConnectRetryDelay contains the polling interval to retry the connection with the provisioner
-const ConnectRetryDelay = time.Second * 30
+// Monitor defines the common behaviour of the monitors that follow long-running operations.
+type Monitor interface {
+	// RegisterProgressEvents registers a channel that notifies that a progress event has been received for the
+	// operation, so that the monitor checks its progress without waiting for the next poll.
+	RegisterProgressEvents(events <-chan struct{})
+	// LaunchMonitor follows the operation until it finishes and triggers the registered callbacks.
+	LaunchMonitor()
+	// SetDeadline sets the time after which the monitor gives up waiting for the operation. The registered
+	// callbacks receive a DeadlineExceeded error in that case.
+	SetDeadline(deadline time.Time)
+	// Expired returns true if the monitor exited because the deadline was reached.
+	Expired() bool
+	// SetPollPolicy sets how often the monitor checks the progress of the operation.
+	SetPollPolicy(policy PollPolicy)
+	// Suspend stops the monitor leaving the operation untouched on the remote component so that it can be
+	// resumed later. The registered callbacks are not triggered. It returns false if the operation had already
+	// finished, in which case the callbacks are triggered normally.
+	Suspend() bool
+	// Suspended returns true if the monitor exited because it was suspended.
+	Suspended() bool
+}
+
+// stopper is embedded in the monitors to support their suspension, deadlines and polling policy.
+type stopper struct {
+	mutex    sync.Mutex
+	stop     chan struct{}
+	finished bool
+	// suspended is set when the monitor is stopped leaving the operation on the remote component.
+	suspended bool
+	// deadline contains the time when the monitor gives up, if set.
+	deadline time.Time
+	// expired is set when the monitor exits because of the deadline. It is only accessed by the monitor.
+	expired bool
+	policy  PollPolicy
+	backoff *backoff
+}
+
+func newStopper() *stopper {
+	policy := DefaultPollPolicy()
+	return &stopper{
+		stop:    make(chan struct{}),
+		policy:  policy,
+		backoff: newBackoff(policy),
+	}
+}
+
+// Suspend requests the monitor to stop leaving the operation untouched on the remote component. It returns false
+// if the operation had already finished.
+func (s *stopper) Suspend() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.finished {
+		return false
+	}
+	if !s.suspended {
+		s.suspended = true
+		close(s.stop)
+	}
+	return true
+}
+
+// Suspended returns true if the monitor was suspended.
+func (s *stopper) Suspended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.suspended
+}
+
+// finish marks the operation as finished. It returns false if the monitor has been suspended.
+func (s *stopper) finish() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.suspended {
+		return false
+	}
+	s.finished = true
+	return true
+}
+
+// SetDeadline sets the time after which the monitor gives up waiting for the operation.
+func (s *stopper) SetDeadline(deadline time.Time) {
+	s.deadline = deadline
+}
+
+// Expired returns true if the monitor exited because the deadline was reached.
+func (s *stopper) Expired() bool {
+	return s.expired
+}
+
+// deadlineError returns the error passed to the callbacks of an expired monitor.
+func (s *stopper) deadlineError() derrors.Error {
+	return derrors.NewDeadlineExceededError("operation did not finish before its deadline").WithParams(s.deadline.String())
+}
+
+// SetPollPolicy sets how often the monitor checks the progress of the operation.
+func (s *stopper) SetPollPolicy(policy PollPolicy) {
+	s.policy = policy
+	s.backoff = newBackoff(policy)
+}
+
+// waitForProgress blocks until the next progress check is due following a successful one, so the backoff of the
+// failures is reset. If a channel of progress events is available, the wait also finishes as soon as an event is
+// received. It returns false if the monitor is suspended or its deadline is reached while waiting.
+func (s *stopper) waitForProgress(events <-chan struct{}) bool {
+	s.backoff.reset()
+	return s.wait(s.backoff.next(), events)
+}
+
+// waitForRetry blocks until the connection with the remote component can be retried. The wait increases with each
+// consecutive failure. It returns false if the monitor is suspended or its deadline is reached while waiting.
+func (s *stopper) waitForRetry() bool {
+	return s.wait(s.backoff.next(), nil)
+}
+
+// wait blocks for a given delay or until an event is received.
+func (s *stopper) wait(delay time.Duration, events <-chan struct{}) bool {
+	var deadline <-chan time.Time
+	if !s.deadline.IsZero() {
+		deadlineTimer := time.NewTimer(time.Until(s.deadline))
+		defer deadlineTimer.Stop()
+		deadline = deadlineTimer.C
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-events:
+	case <-timer.C:
+	case <-deadline:
+		s.expired = true
+		return false
+	case <-s.stop:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PollPolicy defines how often a monitor checks the progress of an operation, and how many communication failures
+// are tolerated. The progress is checked every InitialInterval. After a communication failure the interval is
+// multiplied by Multiplier on each consecutive failure until it reaches MaxInterval, and it goes back to
+// InitialInterval once a check succeeds. Each wait is randomized by a Jitter factor so that monitors launched at
+// the same time do not poll the remote components in lockstep.
+type PollPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter is the fraction of the interval that is randomized, between 0 and 1.
+	Jitter float64
+	// MaxFailures is the number of communication failures allowed until the monitor exits.
+	MaxFailures int
+}
+
+// DefaultPollPolicy returns the policy used by the monitors unless another one is set.
+func DefaultPollPolicy() PollPolicy {
+	return PollPolicy{
+		InitialInterval: QueryDelay,
+		MaxInterval:     QueryDelay,
+		Multiplier:      1,
+		Jitter:          0.1,
+		MaxFailures:     MaxConnFailures,
+	}
+}
+
+// ParsePollPolicy reads a policy with the format initial,max,multiplier,jitter,maxFailures such as 30s,2m,1.5,0.2,5.
+func ParsePollPolicy(value string) (*PollPolicy, derrors.Error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 5 {
+		return nil, derrors.NewInvalidArgumentError("poll policy must have the format initial,max,multiplier,jitter,maxFailures").WithParams(value)
+	}
+	initial, err := time.ParseDuration(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return nil, derrors.AsError(err, "invalid initial interval in poll policy")
+	}
+	maxInterval, err := time.ParseDuration(strings.TrimSpace(fields[1]))
+	if err != nil {
+		return nil, derrors.AsError(err, "invalid max interval in poll policy")
+	}
+	multiplier, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+	if err != nil {
+		return nil, derrors.AsError(err, "invalid multiplier in poll policy")
+	}
+	jitterFraction, err := strconv.ParseFloat(strings.TrimSpace(fields[3]), 64)
+	if err != nil {
+		return nil, derrors.AsError(err, "invalid jitter in poll policy")
+	}
+	maxFailures, err := strconv.Atoi(strings.TrimSpace(fields[4]))
+	if err != nil {
+		return nil, derrors.AsError(err, "invalid max failures in poll policy")
+	}
+	policy := &PollPolicy{
+		InitialInterval: initial,
+		MaxInterval:     maxInterval,
+		Multiplier:      multiplier,
+		Jitter:          jitterFraction,
+		MaxFailures:     maxFailures,
+	}
+	vErr := policy.Validate()
+	if vErr != nil {
+		return nil, vErr
+	}
+	return policy, nil
+}
+
+// Validate checks that the values of the policy are consistent.
+func (p *PollPolicy) Validate() derrors.Error {
+	if p.InitialInterval <= 0 {
+		return derrors.NewInvalidArgumentError("initial interval must be positive")
+	}
+	if p.MaxInterval < p.InitialInterval {
+		return derrors.NewInvalidArgumentError("max interval cannot be lower than the initial interval")
+	}
+	if p.Multiplier < 1 {
+		return derrors.NewInvalidArgumentError("multiplier cannot be lower than 1")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return derrors.NewInvalidArgumentError("jitter must be between 0 and 1")
+	}
+	if p.MaxFailures <= 0 {
+		return derrors.NewInvalidArgumentError("max failures must be positive")
+	}
+	return nil
+}
+
+// String returns the policy with the format accepted by ParsePollPolicy.
+func (p PollPolicy) String() string {
+	return fmt.Sprintf("%s,%s,%g,%g,%d", p.InitialInterval, p.MaxInterval, p.Multiplier, p.Jitter, p.MaxFailures)
+}
+
+// Set parses a policy from a command line flag.
+func (p *PollPolicy) Set(value string) error {
+	parsed, err := ParsePollPolicy(value)
+	if err != nil {
+		return err
+	}
+	*p = *parsed
+	return nil
+}
+
+// Type returns the name of the flag type.
+func (p *PollPolicy) Type() string {
+	return "pollPolicy"
+}
+
+// backoff computes the successive polling intervals of a policy.
+type backoff struct {
+	policy  PollPolicy
+	current time.Duration
+}
+
+func newBackoff(policy PollPolicy) *backoff {
+	return &backoff{
+		policy:  policy,
+		current: policy.InitialInterval,
+	}
+}
+
+// next returns the time to wait until the next check, and increases the interval for the following one.
+func (b *backoff) next() time.Duration {
+	result := jitter(b.current, b.policy.Jitter)
+	increased := time.Duration(float64(b.current) * b.policy.Multiplier)
+	if increased > b.policy.MaxInterval {
+		increased = b.policy.MaxInterval
+	}
+	b.current = increased
+	return result
+}
+
+// reset goes back to the initial interval.
+func (b *backoff) reset() {
+	b.current = b.policy.InitialInterval
+}
+
+// jitter randomizes an interval by a given fraction in both directions.
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+	delta := (rand.Float64()*2 - 1) * fraction * float64(interval)
+	return interval + time.Duration(delta)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Poll policy", func() {
+
+	ginkgo.It("should parse a valid policy", func() {
+		policy, err := ParsePollPolicy("30s,2m,1.5,0.2,5")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*policy).Should(gomega.Equal(PollPolicy{
+			InitialInterval: time.Second * 30,
+			MaxInterval:     time.Minute * 2,
+			Multiplier:      1.5,
+			Jitter:          0.2,
+			MaxFailures:     5,
+		}))
+		parsed, err := ParsePollPolicy(policy.String())
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*parsed).Should(gomega.Equal(*policy))
+	})
+
+	ginkgo.It("should reject invalid policies", func() {
+		invalid := []string{"", "30s,2m,1.5,0.2", "2m,30s,1.5,0.2,5", "30s,2m,0.5,0.2,5", "30s,2m,1.5,2,5", "30s,2m,1.5,0.2,0", "a,2m,1.5,0.2,5"}
+		for _, value := range invalid {
+			_, err := ParsePollPolicy(value)
+			gomega.Expect(err).ToNot(gomega.Succeed(), value)
+		}
+	})
+
+	ginkgo.It("should increase the interval up to the maximum", func() {
+		b := newBackoff(PollPolicy{InitialInterval: time.Second, MaxInterval: time.Second * 5, Multiplier: 2, MaxFailures: 1})
+		intervals := make([]time.Duration, 0)
+		for i := 0; i < 5; i++ {
+			intervals = append(intervals, b.next())
+		}
+		gomega.Expect(intervals).Should(gomega.Equal([]time.Duration{
+			time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5}))
+		b.reset()
+		gomega.Expect(b.next()).Should(gomega.Equal(time.Second))
+	})
+
+	ginkgo.It("should randomize the interval within the jitter", func() {
+		for i := 0; i < 100; i++ {
+			value := jitter(time.Second*10, 0.2)
+			gomega.Expect(value).Should(gomega.BeNumerically(">=", time.Second*8))
+			gomega.Expect(value).Should(gomega.BeNumerically("<=", time.Second*12))
+		}
+	})
+})
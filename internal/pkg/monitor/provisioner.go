@@ -25,7 +25,6 @@ import (
 	"github.com/nalej/grpc-provisioner-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
-	"time"
 )
 
 // ProvisionerMonitor structure with the required clients to read and update states of a provision.
@@ -34,6 +33,8 @@ type ProvisionerMonitor struct {
 	clusterClient       grpc_infrastructure_go.ClustersClient
 	provisionerResponse grpc_infrastructure_manager_go.ProvisionerResponse
 	callback            func(string, string, string, *grpc_provisioner_go.ProvisionClusterResponse, derrors.Error)
+	events              <-chan struct{}
+	*stopper
 }
 
 // NewProvisionerMonitor creates a new monitor with a set of clients.
@@ -46,6 +47,7 @@ func NewProvisionerMonitor(
 		clusterClient:       clusterClient,
 		provisionerResponse: provisionerResponse,
 		callback:            nil,
+		stopper:             newStopper(),
 	}
 }
 
@@ -55,6 +57,11 @@ func (m *ProvisionerMonitor) RegisterCallback(callback func(requestID string, or
 	m.callback = callback
 }
 
+// RegisterProgressEvents registers a channel that notifies the reception of progress events for the operation.
+func (m *ProvisionerMonitor) RegisterProgressEvents(events <-chan struct{}) {
+	m.events = events
+}
+
 // LaunchMonitor periodically monitors the state of a provision waiting for it to complete.
 func (m *ProvisionerMonitor) LaunchMonitor() {
 	log.Debug().Str("clusterID", m.provisionerResponse.ClusterId).
@@ -64,7 +71,7 @@ func (m *ProvisionerMonitor) LaunchMonitor() {
 		RequestId: m.provisionerResponse.RequestId,
 	}
 	exit := false
-	remainingFailures := MaxConnFailures
+	remainingFailures := m.policy.MaxFailures
 	var status *grpc_provisioner_go.ProvisionClusterResponse
 	var err error
 	for !exit {
@@ -76,17 +83,21 @@ func (m *ProvisionerMonitor) LaunchMonitor() {
 				log.Warn().Str("requestID", requestID.RequestId).Msg("Cannot contact provisioner")
 				exit = true
 			} else {
-				time.Sleep(ConnectRetryDelay)
+				exit = !m.waitForRetry()
 			}
 		} else {
 			log.Debug().Str("requestID", requestID.RequestId).Int64("elapsed", status.ElapsedTime).Str("state", status.State.String()).Msg("processing provision progress")
 			if status.Error != "" || status.State == grpc_provisioner_go.ProvisionProgress_ERROR || status.State == grpc_provisioner_go.ProvisionProgress_FINISHED {
 				exit = true
 			} else {
-				time.Sleep(QueryDelay)
+				exit = !m.waitForProgress(m.events)
 			}
 		}
 	}
+	if !m.finish() {
+		log.Info().Str("requestID", requestID.RequestId).Msg("Provision monitor suspended")
+		return
+	}
 	m.notify(status, err)
 	log.Debug().Str("clusterID", m.provisionerResponse.ClusterId).
 		Str("requestID", m.provisionerResponse.RequestId).Msg("Provision monitor exits")
@@ -103,7 +114,9 @@ func (m *ProvisionerMonitor) notify(lastResponse *grpc_provisioner_go.ProvisionC
 			Str("err", conversions.ToDerror(rErr).DebugReport()).Msg("Cannot remove provision from provisioner")
 	}
 	var cErr derrors.Error
-	if err != nil {
+	if m.Expired() {
+		cErr = m.deadlineError()
+	} else if err != nil {
 		cErr = conversions.ToDerror(err)
 	}
 	if m.callback != nil {
@@ -33,6 +33,8 @@ type ScalerMonitor struct {
 	scaleClient         grpc_provisioner_go.ScaleClient
 	provisionerResponse grpc_infrastructure_manager_go.ProvisionerResponse
 	callback            func(string, string, string, *grpc_provisioner_go.ScaleClusterResponse, derrors.Error)
+	events              <-chan struct{}
+	*stopper
 }
 
 // NewScalerMonitor creates a new monitor with a set of clients.
@@ -43,6 +45,7 @@ func NewScalerMonitor(
 		scaleClient:         scaleClient,
 		provisionerResponse: provisionerResponse,
 		callback:            nil,
+		stopper:             newStopper(),
 	}
 }
 
@@ -52,6 +55,11 @@ func (m *ScalerMonitor) RegisterCallback(callback func(requestID string, organiz
 	m.callback = callback
 }
 
+// RegisterProgressEvents registers a channel that notifies the reception of progress events for the operation.
+func (m *ScalerMonitor) RegisterProgressEvents(events <-chan struct{}) {
+	m.events = events
+}
+
 // LaunchMonitor periodically monitors the state of a scaling operation waiting for it to complete.
 func (m *ScalerMonitor) LaunchMonitor() {
 	log.Debug().Str("clusterID", m.provisionerResponse.ClusterId).
@@ -61,7 +69,7 @@ func (m *ScalerMonitor) LaunchMonitor() {
 		RequestId: m.provisionerResponse.RequestId,
 	}
 	exit := false
-	remainingFailures := MaxConnFailures
+	remainingFailures := m.policy.MaxFailures
 	var status *grpc_provisioner_go.ScaleClusterResponse
 	var err error
 	for !exit {
@@ -75,17 +83,21 @@ func (m *ScalerMonitor) LaunchMonitor() {
 				log.Warn().Str("requestID", requestID.RequestId).Msg("Cannot contact provisioner component")
 				exit = true
 			} else {
-				time.Sleep(ConnectRetryDelay)
+				exit = !m.waitForRetry()
 			}
 		} else {
 			log.Debug().Str("requestID", requestID.RequestId).Int64("elapsed", status.ElapsedTime).Str("state", status.State.String()).Msg("processing scaling progress")
 			if status.Error != "" || status.State == grpc_provisioner_go.ProvisionProgress_ERROR || status.State == grpc_provisioner_go.ProvisionProgress_FINISHED {
 				exit = true
 			} else {
-				time.Sleep(QueryDelay)
+				exit = !m.waitForProgress(m.events)
 			}
 		}
 	}
+	if !m.finish() {
+		log.Info().Str("requestID", requestID.RequestId).Msg("Scale monitor suspended")
+		return
+	}
 	m.notify(status, err)
 	log.Debug().Str("clusterID", m.provisionerResponse.ClusterId).
 		Str("requestID", m.provisionerResponse.RequestId).Msg("Scale monitor exits")
@@ -104,7 +116,9 @@ func (m *ScalerMonitor) notify(lastResponse *grpc_provisioner_go.ScaleClusterRes
 			Str("err", conversions.ToDerror(rErr).DebugReport()).Msg("Cannot remove scale operation from provisioner")
 	}
 	var cErr derrors.Error
-	if err != nil {
+	if m.Expired() {
+		cErr = m.deadlineError()
+	} else if err != nil {
 		cErr = conversions.ToDerror(err)
 	}
 	if m.callback != nil {
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Monitor stopper", func() {
+
+	ginkgo.It("should stop waiting when suspended", func() {
+		s := newStopper()
+		gomega.Expect(s.Suspend()).Should(gomega.BeTrue())
+		gomega.Expect(s.wait(time.Minute, nil)).Should(gomega.BeFalse())
+		gomega.Expect(s.finish()).Should(gomega.BeFalse())
+		gomega.Expect(s.Suspended()).Should(gomega.BeTrue())
+		gomega.Expect(s.Expired()).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should not suspend finished operations", func() {
+		s := newStopper()
+		gomega.Expect(s.finish()).Should(gomega.BeTrue())
+		gomega.Expect(s.Suspend()).Should(gomega.BeFalse())
+		gomega.Expect(s.Suspended()).Should(gomega.BeFalse())
+	})
+})
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Quotas limit the infrastructure that each organization may create.
+
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+)
+
+// Limits contains the maximum infrastructure of an organization. A zero value means that there is no limit.
+type Limits struct {
+	MaxClusters             int `json:"max_clusters"`
+	MaxNodes                int `json:"max_nodes"`
+	MaxNodesPerCluster      int `json:"max_nodes_per_cluster"`
+	MaxConcurrentOperations int `json:"max_concurrent_operations"`
+}
+
+// Validate checks that the values of the limits are consistent.
+func (l *Limits) Validate() derrors.Error {
+	if l.MaxClusters < 0 || l.MaxNodes < 0 || l.MaxNodesPerCluster < 0 || l.MaxConcurrentOperations < 0 {
+		return derrors.NewInvalidArgumentError("quota limits cannot be negative")
+	}
+	if l.MaxNodes > 0 && l.MaxNodesPerCluster > l.MaxNodes {
+		return derrors.NewInvalidArgumentError("max_nodes_per_cluster cannot be greater than max_nodes")
+	}
+	return nil
+}
+
+// Limited returns true if any of the limits is set.
+func (l *Limits) Limited() bool {
+	return l.MaxClusters > 0 || l.MaxNodes > 0 || l.MaxNodesPerCluster > 0 || l.MaxConcurrentOperations > 0
+}
+
+// Usage contains the infrastructure currently used by an organization.
+type Usage struct {
+	Clusters   int
+	Nodes      int
+	Operations int
+}
+
+// exceeded returns the error sent when a request would go over a limit.
+func exceeded(resource string, limit int, requested int) derrors.Error {
+	return derrors.NewFailedPreconditionError(fmt.Sprintf("quota exceeded, %s limited to %d", resource, limit)).
+		WithParams(resource, limit, requested)
+}
+
+// checkOperations checks that one more operation can be launched.
+func (l *Limits) checkOperations(usage Usage) derrors.Error {
+	if l.MaxConcurrentOperations > 0 && usage.Operations+1 > l.MaxConcurrentOperations {
+		return exceeded("concurrent operations", l.MaxConcurrentOperations, usage.Operations+1)
+	}
+	return nil
+}
+
+// CheckProvision checks that a new cluster with a given number of nodes fits in the limits.
+func (l *Limits) CheckProvision(usage Usage, numNodes int) derrors.Error {
+	if l.MaxClusters > 0 && usage.Clusters+1 > l.MaxClusters {
+		return exceeded("clusters", l.MaxClusters, usage.Clusters+1)
+	}
+	if l.MaxNodesPerCluster > 0 && numNodes > l.MaxNodesPerCluster {
+		return exceeded("nodes per cluster", l.MaxNodesPerCluster, numNodes)
+	}
+	if l.MaxNodes > 0 && usage.Nodes+numNodes > l.MaxNodes {
+		return exceeded("nodes", l.MaxNodes, usage.Nodes+numNodes)
+	}
+	return l.checkOperations(usage)
+}
+
+// CheckScale checks that a cluster with currentNodes nodes can be scaled to numNodes. Scale downs are always allowed
+// by the node limits so that an organization above its quota can reduce its usage.
+func (l *Limits) CheckScale(usage Usage, currentNodes int, numNodes int) derrors.Error {
+	if numNodes > currentNodes {
+		if l.MaxNodesPerCluster > 0 && numNodes > l.MaxNodesPerCluster {
+			return exceeded("nodes per cluster", l.MaxNodesPerCluster, numNodes)
+		}
+		total := usage.Nodes - currentNodes + numNodes
+		if l.MaxNodes > 0 && total > l.MaxNodes {
+			return exceeded("nodes", l.MaxNodes, total)
+		}
+	}
+	return l.checkOperations(usage)
+}
+
+// Quotas contains the default limits and the limits of the organizations that override them.
+type Quotas struct {
+	Default       Limits            `json:"default"`
+	Organizations map[string]Limits `json:"organizations"`
+}
+
+// Unlimited returns a set of quotas without any limit.
+func Unlimited() *Quotas {
+	return &Quotas{Organizations: map[string]Limits{}}
+}
+
+// For returns the limits of an organization.
+func (q *Quotas) For(organizationID string) Limits {
+	limits, exists := q.Organizations[organizationID]
+	if exists {
+		return limits
+	}
+	return q.Default
+}
+
+// Validate checks the default limits and the limits of each organization.
+func (q *Quotas) Validate() derrors.Error {
+	err := q.Default.Validate()
+	if err != nil {
+		return err
+	}
+	for organizationID, limits := range q.Organizations {
+		err = limits.Validate()
+		if err != nil {
+			return derrors.NewInvalidArgumentError("invalid quota of organization", err).WithParams(organizationID)
+		}
+	}
+	return nil
+}
+
+// LoadQuotas reads a JSON file with the quotas, such as {"default": {"max_clusters": 5, "max_nodes": 50},
+// "organizations": {"org": {"max_clusters": 10, "max_nodes": 100, "max_nodes_per_cluster": 20,
+// "max_concurrent_operations": 3}}}. The limits of an organization replace the default ones.
+func LoadQuotas(path string) (*Quotas, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read quotas")
+	}
+	result := Unlimited()
+	err = json.Unmarshal(content, result)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot unmarshal quotas")
+	}
+	if result.Organizations == nil {
+		result.Organizations = map[string]Limits{}
+	}
+	vErr := result.Validate()
+	if vErr != nil {
+		return nil, vErr
+	}
+	return result, nil
+}
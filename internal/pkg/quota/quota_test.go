@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quota
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Quotas", func() {
+
+	limits := Limits{MaxClusters: 2, MaxNodes: 10, MaxNodesPerCluster: 6, MaxConcurrentOperations: 1}
+
+	ginkgo.It("should use the default limits of organizations without quotas", func() {
+		quotas := &Quotas{Default: Limits{MaxClusters: 1}, Organizations: map[string]Limits{"org": limits}}
+		gomega.Expect(quotas.For("org")).Should(gomega.Equal(limits))
+		gomega.Expect(quotas.For("otherOrg").MaxClusters).Should(gomega.Equal(1))
+		gomega.Expect(quotas.For("org").Limited()).Should(gomega.BeTrue())
+		gomega.Expect(Unlimited().For("org").Limited()).Should(gomega.BeFalse())
+		gomega.Expect(Unlimited().For("org").CheckProvision(Usage{Clusters: 100, Nodes: 1000}, 50)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should check the limits of a new cluster", func() {
+		gomega.Expect(limits.CheckProvision(Usage{Clusters: 1, Nodes: 4}, 6)).To(gomega.Succeed())
+		gomega.Expect(limits.CheckProvision(Usage{Clusters: 2}, 1)).ToNot(gomega.Succeed())
+		gomega.Expect(limits.CheckProvision(Usage{}, 7)).ToNot(gomega.Succeed())
+		gomega.Expect(limits.CheckProvision(Usage{Clusters: 1, Nodes: 5}, 6)).ToNot(gomega.Succeed())
+		gomega.Expect(limits.CheckProvision(Usage{Operations: 1}, 1)).ToNot(gomega.Succeed())
+	})
+
+	ginkgo.It("should check the limits of a scale operation", func() {
+		gomega.Expect(limits.CheckScale(Usage{Clusters: 2, Nodes: 8}, 4, 6)).To(gomega.Succeed())
+		gomega.Expect(limits.CheckScale(Usage{Clusters: 2, Nodes: 8}, 4, 7)).ToNot(gomega.Succeed())
+		gomega.Expect(limits.CheckScale(Usage{Clusters: 2, Nodes: 9}, 4, 6)).ToNot(gomega.Succeed())
+		// Scale downs are allowed above the node limits, but not above the concurrent operations.
+		gomega.Expect(limits.CheckScale(Usage{Clusters: 2, Nodes: 12}, 8, 7)).To(gomega.Succeed())
+		gomega.Expect(limits.CheckScale(Usage{Clusters: 2, Nodes: 12, Operations: 1}, 8, 7)).ToNot(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject inconsistent limits", func() {
+		gomega.Expect((&Limits{MaxNodes: -1}).Validate()).ToNot(gomega.Succeed())
+		gomega.Expect((&Limits{MaxNodes: 5, MaxNodesPerCluster: 6}).Validate()).ToNot(gomega.Succeed())
+		gomega.Expect((&Quotas{Organizations: map[string]Limits{"org": {MaxClusters: -1}}}).Validate()).ToNot(gomega.Succeed())
+	})
+})
@@ -18,8 +18,13 @@ package server
 
 import (
 	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/leader"
+	"github.com/nalej/infrastructure-manager/internal/pkg/monitor"
+	"github.com/nalej/infrastructure-manager/internal/pkg/server/infrastructure"
 	"github.com/nalej/infrastructure-manager/version"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 type Config struct {
@@ -37,6 +42,52 @@ type Config struct {
 	QueueAddress string
 	// Debug mode
 	Debug bool
+	// ProgressEvents enables consuming the progress events published by the provisioner and the installer.
+	ProgressEvents bool
+	// IdempotencyKeyRetention is the time the responses of requests with an idempotency key are remembered.
+	IdempotencyKeyRetention time.Duration
+	// ProvisionDeadline is the maximum duration of a provision operation.
+	ProvisionDeadline time.Duration
+	// InstallDeadline is the maximum duration of an install operation.
+	InstallDeadline time.Duration
+	// ScaleDeadline is the maximum duration of a scale operation.
+	ScaleDeadline time.Duration
+	// UninstallDeadline is the maximum duration of an uninstall operation.
+	UninstallDeadline time.Duration
+	// DecommissionDeadline is the maximum duration of a decommission operation.
+	DecommissionDeadline time.Duration
+	// RemoveDeadline is the maximum time to cordon and drain a cluster being removed.
+	RemoveDeadline time.Duration
+	// RemoveNodesDeadline is the maximum time to drain each node being removed.
+	RemoveNodesDeadline time.Duration
+	// ProvisionPollPolicy defines how often the progress of a provision is checked.
+	ProvisionPollPolicy monitor.PollPolicy
+	// InstallPollPolicy defines how often the progress of an install is checked.
+	InstallPollPolicy monitor.PollPolicy
+	// ScalePollPolicy defines how often the progress of a scale is checked.
+	ScalePollPolicy monitor.PollPolicy
+	// UninstallPollPolicy defines how often the progress of an uninstall is checked.
+	UninstallPollPolicy monitor.PollPolicy
+	// DecommissionPollPolicy defines how often the progress of a decommission is checked.
+	DecommissionPollPolicy monitor.PollPolicy
+	// ShutdownTimeout is the time given to each shutdown step: checkpointing the running monitors and draining
+	// the in-flight requests.
+	ShutdownTimeout time.Duration
+	// LeaderElection enables running several replicas. Only the elected leader runs the monitors of the operations,
+	// which are stored in a journal shared through secrets.
+	LeaderElection bool
+	// Election contains the settings of the leader election.
+	Election leader.Config
+	// NodeReconcileInterval is the time between two reconciliations of the nodes of the installed clusters.
+	NodeReconcileInterval time.Duration
+	// AutoscalerInterval is the time between two evaluations of the autoscaler policies.
+	AutoscalerInterval time.Duration
+	// AutoscalerPolicies is the path of the file with the autoscaler policies of the clusters.
+	AutoscalerPolicies string
+	// AutoscalerAuditLog is the path of the file where the decisions of the autoscaler are recorded.
+	AutoscalerAuditLog string
+	// Quotas is the path of the file with the infrastructure limits of the organizations.
+	Quotas string
 }
 
 func (conf *Config) Validate() derrors.Error {
@@ -55,9 +106,61 @@ func (conf *Config) Validate() derrors.Error {
 	if conf.QueueAddress == "" {
 		return derrors.NewInvalidArgumentError("queueAddress must be set")
 	}
+	if conf.IdempotencyKeyRetention <= 0 {
+		return derrors.NewInvalidArgumentError("idempotencyKeyRetention must be positive")
+	}
+	if conf.ProvisionDeadline < 0 || conf.InstallDeadline < 0 || conf.ScaleDeadline < 0 ||
+		conf.UninstallDeadline < 0 || conf.DecommissionDeadline < 0 || conf.RemoveDeadline < 0 ||
+		conf.RemoveNodesDeadline < 0 {
+		return derrors.NewInvalidArgumentError("operation deadlines cannot be negative")
+	}
+	if conf.NodeReconcileInterval < 0 {
+		return derrors.NewInvalidArgumentError("nodeReconcileInterval cannot be negative")
+	}
+	if conf.AutoscalerInterval < 0 {
+		return derrors.NewInvalidArgumentError("autoscalerInterval cannot be negative")
+	}
+	if conf.ShutdownTimeout <= 0 {
+		return derrors.NewInvalidArgumentError("shutdownTimeout must be positive")
+	}
+	if conf.LeaderElection {
+		err := conf.Election.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, policy := range []monitor.PollPolicy{conf.ProvisionPollPolicy, conf.InstallPollPolicy,
+		conf.ScalePollPolicy, conf.UninstallPollPolicy, conf.DecommissionPollPolicy} {
+		err := policy.Validate()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// GetOperationConfig returns the settings used by the manager to follow the operations.
+func (conf *Config) GetOperationConfig() infrastructure.OperationConfig {
+	return infrastructure.OperationConfig{
+		Deadlines: map[entities.OperationType]time.Duration{
+			entities.ProvisionOperation:    conf.ProvisionDeadline,
+			entities.InstallOperation:      conf.InstallDeadline,
+			entities.ScaleOperation:        conf.ScaleDeadline,
+			entities.UninstallOperation:    conf.UninstallDeadline,
+			entities.DecommissionOperation: conf.DecommissionDeadline,
+			entities.RemoveOperation:       conf.RemoveDeadline,
+			entities.RemoveNodesOperation:  conf.RemoveNodesDeadline,
+		},
+		PollPolicies: map[entities.OperationType]monitor.PollPolicy{
+			entities.ProvisionOperation:    conf.ProvisionPollPolicy,
+			entities.InstallOperation:      conf.InstallPollPolicy,
+			entities.ScaleOperation:        conf.ScalePollPolicy,
+			entities.UninstallOperation:    conf.UninstallPollPolicy,
+			entities.DecommissionOperation: conf.DecommissionPollPolicy,
+		},
+	}
+}
+
 func (conf *Config) Print() {
 	log.Info().Str("app", version.AppVersion).Str("commit", version.Commit).Msg("Version")
 	log.Info().Int("port", conf.Port).Msg("gRPC port")
@@ -66,4 +169,36 @@ func (conf *Config) Print() {
 	log.Info().Str("URL", conf.ProvisionerAddress).Msg("Provisioner")
 	log.Info().Str("URL", conf.InstallerAddress).Msg("Installer")
 	log.Info().Str("URL", conf.QueueAddress).Msg("Queue")
+	log.Info().Bool("enabled", conf.ProgressEvents).Msg("Progress events")
+	log.Info().Str("retention", conf.IdempotencyKeyRetention.String()).Msg("Idempotency keys")
+	log.Info().Str("provision", conf.ProvisionDeadline.String()).Str("install", conf.InstallDeadline.String()).
+		Str("scale", conf.ScaleDeadline.String()).Str("uninstall", conf.UninstallDeadline.String()).
+		Str("decommission", conf.DecommissionDeadline.String()).Str("remove", conf.RemoveDeadline.String()).
+		Str("removeNodes", conf.RemoveNodesDeadline.String()).
+		Msg("Operation deadlines")
+	log.Info().Str("provision", conf.ProvisionPollPolicy.String()).Str("install", conf.InstallPollPolicy.String()).
+		Str("scale", conf.ScalePollPolicy.String()).Str("uninstall", conf.UninstallPollPolicy.String()).
+		Str("decommission", conf.DecommissionPollPolicy.String()).Msg("Poll policies")
+	log.Info().Str("timeout", conf.ShutdownTimeout.String()).Msg("Shutdown")
+	log.Info().Str("interval", conf.NodeReconcileInterval.String()).Msg("Node reconciliation")
+	if conf.AutoscalerPolicies != "" {
+		log.Info().Str("interval", conf.AutoscalerInterval.String()).Str("policies", conf.AutoscalerPolicies).
+			Str("auditLog", conf.AutoscalerAuditLog).Msg("Autoscaler")
+	} else {
+		log.Info().Msg("Autoscaler disabled")
+	}
+	if conf.Quotas != "" {
+		log.Info().Str("path", conf.Quotas).Msg("Quotas")
+	} else {
+		log.Info().Msg("Quotas disabled")
+	}
+	if conf.LeaderElection {
+		log.Info().Str("namespace", conf.Election.Namespace).Str("lease", conf.Election.LeaseName).
+			Str("identity", conf.Election.Identity).Str("leaseDuration", conf.Election.LeaseDuration.String()).
+			Str("renewDeadline", conf.Election.RenewDeadline.String()).Str("retryPeriod", conf.Election.RetryPeriod.String()).
+			Msg("Leader election")
+	} else {
+		log.Info().Msg("Leader election disabled")
+		log.Warn().Str("path", conf.TempDir).Msg("the journal and the kubeconfigs are only durable if tempDir is a persistent volume")
+	}
 }
@@ -124,3 +124,17 @@ func (dh *DiscoveryHelper) Discover() (*entities.Cluster, derrors.Error) {
 		Nodes:                nodes,
 	}, nil
 }
+
+// Usage reads the capacity of the nodes of the cluster and the resources requested by its pods.
+func (dh *DiscoveryHelper) Usage() (*entities.ClusterUsage, derrors.Error) {
+	opts := metaV1.ListOptions{}
+	nodeList, err := dh.Client.CoreV1().Nodes().List(opts)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read nodes")
+	}
+	podList, err := dh.Client.CoreV1().Pods(metaV1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read pods")
+	}
+	return entities.NewClusterUsage(nodeList.Items, podList.Items), nil
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/autoscaler"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/server/discovery/k8s"
+	"github.com/rs/zerolog/log"
+	"github.com/satori/go.uuid"
+	"os"
+	"time"
+)
+
+// DefaultAutoscalerInterval is the time between two evaluations of the autoscaler policies.
+const DefaultAutoscalerInterval = time.Minute
+
+// Autoscale periodically evaluates the policy of each cluster and launches the scale operations required to keep
+// its utilization close to the target. Every decision is recorded in the audit log. Only the installed clusters
+// provisioned by the platform can be scaled. The method blocks until the manager shuts down, and it is expected to run
+// only on the leader.
+func (m *Manager) Autoscale(interval time.Duration, policies map[string]autoscaler.Policy, auditLog autoscaler.AuditLog) {
+	if interval <= 0 || len(policies) == 0 {
+		log.Info().Msg("autoscaler disabled")
+		return
+	}
+	log.Info().Str("interval", interval.String()).Int("policies", len(policies)).Msg("autoscaler started")
+	// lastScale contains the time of the last scale launched on each cluster, to apply the cooldown of its policy.
+	lastScale := make(map[string]time.Time, len(policies))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.autoscaleAll(policies, auditLog, lastScale)
+		case <-m.shutdown.stopped:
+			log.Info().Msg("autoscaler stopped")
+			return
+		}
+	}
+}
+
+// autoscaleAll evaluates the policies of every cluster.
+func (m *Manager) autoscaleAll(policies map[string]autoscaler.Policy, auditLog autoscaler.AuditLog, lastScale map[string]time.Time) {
+	if !m.shutdown.begin() {
+		return
+	}
+	defer m.shutdown.end()
+	for key, policy := range policies {
+		decision, err := m.autoscaleCluster(policy, lastScale[key])
+		if err != nil {
+			log.Warn().Str("organizationID", policy.OrganizationID).Str("clusterID", policy.ClusterID).
+				Str("trace", err.DebugReport()).Msg("cannot evaluate the autoscaler policy of the cluster")
+			continue
+		}
+		if decision == nil {
+			continue
+		}
+		if decision.Action != autoscaler.NoAction && decision.Error == "" {
+			lastScale[key] = time.Unix(decision.Timestamp, 0)
+		}
+		m.recordDecision(auditLog, *decision)
+	}
+}
+
+// autoscaleCluster measures the usage of a cluster and launches a scale operation if its policy requires it. Clusters
+// that are not installed or that have an ongoing operation are skipped, returning no decision.
+func (m *Manager) autoscaleCluster(policy autoscaler.Policy, lastScale time.Time) (*autoscaler.Decision, derrors.Error) {
+	requestID := uuid.NewV4().String()
+	lErr := m.clusterLocks.Acquire(policy.OrganizationID, policy.ClusterID, requestID, entities.ScaleOperation)
+	if lErr != nil {
+		log.Debug().Str("clusterID", policy.ClusterID).Msg("cluster has an ongoing operation, skipping autoscaler")
+		return nil, nil
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			m.clusterLocks.Release(policy.OrganizationID, policy.ClusterID, requestID)
+		}
+	}()
+	cluster, err := m.getCluster(policy.OrganizationID, policy.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.State != grpc_infrastructure_go.ClusterState_INSTALLED {
+		return nil, nil
+	}
+	imported, err := m.isImported(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if imported {
+		return nil, derrors.NewFailedPreconditionError("imported clusters cannot be scaled by the provisioner").WithParams(policy.ClusterID)
+	}
+	usage, err := m.clusterUsage(requestID, policy)
+	if err != nil {
+		return nil, err
+	}
+	decision := autoscaler.Evaluate(policy, *usage, lastScale, time.Now())
+	if decision.Action == autoscaler.NoAction {
+		return &decision, nil
+	}
+	decision.RequestID = requestID
+	scaleRequest := policy.ScaleRequest(requestID, decision.TargetNodes)
+	releaseQuota, err := m.checkScaleQuota(scaleRequest)
+	if err != nil {
+		decision.Error = err.Error()
+		return &decision, nil
+	}
+	// The lock is already held by this request, so the scale operation keeps it until its monitor finishes.
+	// Scale downs decided by the autoscaler are never forced.
+	_, err = m.Scale(scaleRequest, false)
+	releaseQuota()
+	if err != nil {
+		decision.Error = err.Error()
+		return &decision, nil
+	}
+	launched = true
+	return &decision, nil
+}
+
+// clusterUsage reads the capacity and the requested resources of a provisioned cluster. The kubeconfig is retrieved
+// from the provisioner with the platform of the policy if it is not stored. The size of the cluster is the number of
+// nodes in system model, as it is the one the provisioner scales.
+func (m *Manager) clusterUsage(requestID string, policy autoscaler.Policy) (*entities.ClusterUsage, derrors.Error) {
+	kubeConfig, err := m.clusterKubeConfig(policy.OrganizationID, policy.ClusterID, scaleKubeConfigRequest(policy.ScaleRequest(requestID, 0)))
+	if err != nil {
+		return nil, err
+	}
+	tempFile, err := m.writeTempFile(kubeConfig, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(*tempFile)
+	dh := k8s.NewDiscoveryHelper(*tempFile)
+	err = dh.Connect()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := dh.Usage()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	nodes, nErr := m.nodesClient.ListNodes(ctx, &grpc_infrastructure_go.ClusterId{
+		OrganizationId: policy.OrganizationID,
+		ClusterId:      policy.ClusterID,
+	})
+	if nErr != nil {
+		return nil, conversions.ToDerror(nErr)
+	}
+	usage.Nodes = len(nodes.Nodes)
+	return usage, nil
+}
+
+// recordDecision logs a decision of the autoscaler and stores it in the audit log.
+func (m *Manager) recordDecision(auditLog autoscaler.AuditLog, decision autoscaler.Decision) {
+	log.Info().Str("requestID", decision.RequestID).Str("clusterID", decision.ClusterID).
+		Str("action", string(decision.Action)).Int("currentNodes", decision.CurrentNodes).
+		Int("targetNodes", decision.TargetNodes).Float64("utilization", decision.Utilization).
+		Int("pendingPods", decision.PendingPods).Str("reason", decision.Reason).Str("error", decision.Error).
+		Msg("autoscaler decision")
+	err := auditLog.Record(decision)
+	if err != nil {
+		log.Error().Str("clusterID", decision.ClusterID).Str("trace", err.DebugReport()).Msg("cannot record autoscaler decision")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+)
+
+// IncompleteRegistrationLabel marks the clusters whose registration failed and could not be reverted. Its value is
+// the request that registered the cluster.
+const IncompleteRegistrationLabel = "nalej.io/incomplete-registration"
+
+// compensationAction is the undo action of a step that has already been done.
+type compensationAction struct {
+	description string
+	undo        func() derrors.Error
+}
+
+// Compensation records the undo actions of a process with several steps against system model, so that a failure
+// midway reverts the steps already done instead of leaving partial records behind.
+type Compensation struct {
+	requestID string
+	actions   []compensationAction
+}
+
+// NewCompensation creates an empty compensation for the process of a request.
+func NewCompensation(requestID string) *Compensation {
+	return &Compensation{
+		requestID: requestID,
+		actions:   make([]compensationAction, 0),
+	}
+}
+
+// Add registers the undo action of a step once the step succeeds.
+func (c *Compensation) Add(description string, undo func() derrors.Error) {
+	c.actions = append(c.actions, compensationAction{description: description, undo: undo})
+}
+
+// Run executes the undo actions in the reverse order of their steps. Every action is attempted even if others fail,
+// and the returned error lists the actions that could not be undone. The actions are discarded once executed.
+func (c *Compensation) Run() derrors.Error {
+	failed := make([]string, 0)
+	for i := len(c.actions) - 1; i >= 0; i-- {
+		action := c.actions[i]
+		err := action.undo()
+		if err != nil {
+			log.Error().Str("requestID", c.requestID).Str("action", action.description).
+				Str("trace", err.DebugReport()).Msg("cannot undo step")
+			failed = append(failed, fmt.Sprintf("%s: %s", action.description, err.Error()))
+			continue
+		}
+		log.Debug().Str("requestID", c.requestID).Str("action", action.description).Msg("step undone")
+	}
+	c.actions = make([]compensationAction, 0)
+	if len(failed) > 0 {
+		return derrors.NewInternalError("steps could not be undone").WithParams(failed)
+	}
+	return nil
+}
+
+// removeNodeRecord removes a node from system model.
+func (m *Manager) removeNodeRecord(requestID string, organizationID string, nodeID string) derrors.Error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	_, err := m.nodesClient.RemoveNodes(ctx, &grpc_infrastructure_go.RemoveNodesRequest{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		Nodes:          []string{nodeID},
+	})
+	if err != nil {
+		return conversions.ToDerror(err)
+	}
+	return nil
+}
+
+// removeClusterRecord removes a cluster from system model.
+func (m *Manager) removeClusterRecord(requestID string, organizationID string, clusterID string) derrors.Error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	_, err := m.clusterClient.RemoveCluster(ctx, &grpc_infrastructure_go.RemoveClusterRequest{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		ClusterId:      clusterID,
+	})
+	if err != nil {
+		return conversions.ToDerror(err)
+	}
+	return nil
+}
+
+// revertRegistration undoes the registration of a cluster that failed. If the records cannot be removed, the cluster
+// is labeled so that the partial registration can be found and cleaned up. The cause of the failure is returned.
+func (m *Manager) revertRegistration(compensation *Compensation, organizationID string, clusterID string, cause derrors.Error) derrors.Error {
+	log.Warn().Str("requestID", compensation.requestID).Str("clusterID", clusterID).
+		Str("trace", cause.DebugReport()).Msg("cluster registration failed, reverting")
+	err := compensation.Run()
+	if err == nil {
+		return cause
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	_, lErr := m.clusterClient.UpdateCluster(ctx, &grpc_infrastructure_go.UpdateClusterRequest{
+		OrganizationId: organizationID,
+		ClusterId:      clusterID,
+		AddLabels:      true,
+		Labels:         map[string]string{IncompleteRegistrationLabel: compensation.requestID},
+	})
+	if lErr != nil {
+		log.Error().Str("requestID", compensation.requestID).Str("clusterID", clusterID).
+			Str("trace", conversions.ToDerror(lErr).DebugReport()).Msg("cannot mark the incomplete registration of the cluster")
+	}
+	return cause
+}
+
+// provisionRegistrationFailed reverts the nodes registered after a provision and marks the provision as failed.
+func (m *Manager) provisionRegistrationFailed(requestID string, organizationID string, clusterID string, compensation *Compensation, cause derrors.Error) {
+	log.Error().Str("requestID", requestID).Str("clusterID", clusterID).Str("trace", cause.DebugReport()).
+		Msg("cannot register the provisioned cluster, install will not be triggered")
+	err := compensation.Run()
+	if err != nil {
+		log.Error().Str("requestID", requestID).Str("clusterID", clusterID).
+			Msg("nodes of the provisioned cluster could not be removed from system model")
+	}
+	err = m.updateClusterState(entities.ProvisionOperation, organizationID, clusterID, grpc_infrastructure_go.ClusterState_FAILURE)
+	if err != nil {
+		log.Error().Str("requestID", requestID).Str("clusterID", clusterID).Msg("unable to update cluster state after provision")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("A compensation", func() {
+
+	ginkgo.It("should undo the steps in reverse order", func() {
+		undone := make([]string, 0)
+		compensation := NewCompensation("request")
+		for _, step := range []string{"first", "second", "third"} {
+			name := step
+			compensation.Add(name, func() derrors.Error {
+				undone = append(undone, name)
+				return nil
+			})
+		}
+		gomega.Expect(compensation.Run()).To(gomega.Succeed())
+		gomega.Expect(undone).Should(gomega.Equal([]string{"third", "second", "first"}))
+		gomega.Expect(compensation.Run()).To(gomega.Succeed())
+		gomega.Expect(len(undone)).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("should attempt every step and report the failed ones", func() {
+		executed := 0
+		compensation := NewCompensation("request")
+		compensation.Add("first", func() derrors.Error {
+			executed++
+			return nil
+		})
+		compensation.Add("second", func() derrors.Error {
+			executed++
+			return derrors.NewUnavailableError("system model unavailable")
+		})
+		err := compensation.Run()
+		gomega.Expect(err).ToNot(gomega.BeNil())
+		gomega.Expect(executed).Should(gomega.Equal(2))
+		gomega.Expect(err.DebugReport()).Should(gomega.ContainSubstring("second"))
+	})
+})
@@ -26,71 +26,168 @@ import (
 	"github.com/nalej/grpc-provisioner-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
 	"github.com/satori/go.uuid"
+	"time"
 )
 
 type Handler struct {
 	Manager Manager
+	// idempotency contains the responses of the requests sent with an idempotency key.
+	idempotency *IdempotencyCache
 }
 
-func NewHandler(manager Manager) *Handler {
-	return &Handler{manager}
+// NewHandler creates a new handler. The idempotency keys are persisted in the key store, if any.
+func NewHandler(manager Manager, idempotencyKeyRetention time.Duration, keys journal.KeyStore) *Handler {
+	idempotency := NewIdempotencyCache(idempotencyKeyRetention)
+	if keys != nil {
+		idempotency.Persist(keys, map[string]func() interface{}{
+			"install":   func() interface{} { return &grpc_common_go.OpResponse{} },
+			"provision": func() interface{} { return &grpc_infrastructure_manager_go.ProvisionerResponse{} },
+		})
+	}
+	return &Handler{manager, idempotency}
 }
 
-// InstallCluster installs a new cluster into the system.
+// InstallCluster installs a new cluster into the system. If the client sends an idempotency key, retries of the
+// same request return the original response.
 func (h *Handler) InstallCluster(ctx context.Context, installRequest *grpc_installer_go.InstallRequest) (*grpc_common_go.OpResponse, error) {
 	err := entities.ValidInstallRequest(installRequest)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	installRequest.RequestId = uuid.NewV4().String()
-	return h.Manager.InstallCluster(installRequest)
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	result, iErr := h.idempotency.Execute(installRequest.OrganizationId, "install", GetIdempotencyKey(ctx), installRequest, func() (interface{}, error) {
+		installRequest.RequestId = uuid.NewV4().String()
+		return h.Manager.InstallCluster(installRequest)
+	})
+	if iErr != nil {
+		return nil, iErr
+	}
+	return result.(*grpc_common_go.OpResponse), nil
 }
 
-// ProvisionAndInstallCluster provisions a new kubernetes cluster and then installs it
+// ProvisionAndInstallCluster provisions a new kubernetes cluster, if it fits in the quota of the organization, and
+// then installs it. If the client sends an idempotency key, retries of the same request return the original response.
 func (h *Handler) ProvisionAndInstallCluster(ctx context.Context, provisionRequest *grpc_provisioner_go.ProvisionClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, error) {
 	err := entities.ValidProvisionClusterRequest(provisionRequest)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	provisionRequest.RequestId = uuid.NewV4().String()
-	return h.Manager.ProvisionAndInstallCluster(provisionRequest)
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	result, pErr := h.idempotency.Execute(provisionRequest.OrganizationId, "provision", GetIdempotencyKey(ctx), provisionRequest, func() (interface{}, error) {
+		provisionRequest.RequestId = uuid.NewV4().String()
+		// The quota of the organization is held until the provision is registered.
+		releaseQuota, qErr := h.Manager.checkProvisionQuota(provisionRequest)
+		if qErr != nil {
+			return nil, conversions.ToGRPCError(qErr)
+		}
+		defer releaseQuota()
+		return h.Manager.ProvisionAndInstallCluster(provisionRequest)
+	})
+	if pErr != nil {
+		return nil, pErr
+	}
+	return result.(*grpc_infrastructure_manager_go.ProvisionerResponse), nil
 }
 
-// Scale the number of nodes in the cluster.
-func (h *Handler) Scale(_ context.Context, request *grpc_provisioner_go.ScaleClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, error) {
+// Scale the number of nodes in the cluster if the new size fits in the quota of the organization. Scale downs can be
+// forced with the ForceScaleDownHeader metadata, and a dry run can be requested with the DryRunHeader metadata.
+func (h *Handler) Scale(ctx context.Context, request *grpc_provisioner_go.ScaleClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, error) {
 	err := entities.ValidScaleClusterRequest(request)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	if GetDryRun(ctx) {
+		// The response of a scale has no field for the plan, so it is only sent as response metadata.
+		_, pErr := sendPlan(ctx, h.Manager.PlanScale(request, GetForceScaleDown(ctx)))
+		if pErr != nil {
+			return nil, pErr
+		}
+		return &grpc_infrastructure_manager_go.ProvisionerResponse{
+			OrganizationId: request.OrganizationId,
+			ClusterId:      request.ClusterId,
+		}, nil
+	}
 	request.RequestId = uuid.NewV4().String()
-	result, err := h.Manager.Scale(request)
+	// The quota of the organization is held until the scale is registered.
+	releaseQuota, err := h.Manager.checkScaleQuota(request)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	defer releaseQuota()
+	result, err := h.Manager.Scale(request, GetForceScaleDown(ctx))
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
 	return result, nil
 }
 
-// UninstallCluster proceeds to remove all Nalej created elements in that cluster.
-func (h *Handler) Uninstall(_ context.Context, request *grpc_installer_go.UninstallClusterRequest) (*grpc_common_go.OpResponse, error) {
+// UninstallCluster proceeds to remove all Nalej created elements in that cluster. A dry run can be requested with the
+// DryRunHeader metadata.
+func (h *Handler) Uninstall(ctx context.Context, request *grpc_installer_go.UninstallClusterRequest) (*grpc_common_go.OpResponse, error) {
 	err := entities.ValidUninstallClusterRequest(request)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	if GetDryRun(ctx) {
+		return h.dryRun(ctx, h.Manager.PlanUninstall(request))
+	}
 	request.RequestId = uuid.NewV4().String()
-	result, err := h.Manager.Uninstall(request, nil)
+	result, err := h.Manager.Uninstall(request, nil, false)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
 	return result, nil
 }
 
-// DecommissionCluster frees the resources of a given cluster.
-func (h *Handler) DecommissionCluster(_ context.Context, request *grpc_provisioner_go.DecommissionClusterRequest) (*grpc_common_go.OpResponse, error) {
+// DecommissionCluster frees the resources of a given cluster. A dry run can be requested with the DryRunHeader metadata.
+func (h *Handler) DecommissionCluster(ctx context.Context, request *grpc_provisioner_go.DecommissionClusterRequest) (*grpc_common_go.OpResponse, error) {
 	err := entities.ValidDecommissionClusterRequest(request)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	if GetDryRun(ctx) {
+		return h.dryRun(ctx, h.Manager.PlanDecommission(request))
+	}
 	request.RequestId = uuid.NewV4().String()
 	result, err := h.Manager.UninstallAndDecommissionCluster(request)
 	if err != nil {
@@ -99,6 +196,20 @@ func (h *Handler) DecommissionCluster(_ context.Context, request *grpc_provision
 	return result, nil
 }
 
+// dryRun sends the plan of an operation to the client instead of launching it. The plan is set in the info of the
+// response, and the issues that would block the operation are returned as an error.
+func (h *Handler) dryRun(ctx context.Context, plan *entities.OperationPlan) (*grpc_common_go.OpResponse, error) {
+	content, err := sendPlan(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_common_go.OpResponse{
+		OrganizationId: plan.OrganizationID,
+		Status:         grpc_common_go.OpStatus_SUCCESS,
+		Info:           content,
+	}, nil
+}
+
 // GetCluster retrieves the cluster information.
 func (h *Handler) GetCluster(ctx context.Context, clusterID *grpc_infrastructure_go.ClusterId) (*grpc_infrastructure_go.Cluster, error) {
 	err := entities.ValidClusterId(clusterID)
@@ -160,7 +271,20 @@ func (h *Handler) RemoveCluster(ctx context.Context, removeClusterRequest *grpc_
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	return h.Manager.RemoveCluster(removeClusterRequest)
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	removeClusterRequest.RequestId = uuid.NewV4().String()
+	result, err := h.Manager.RemoveCluster(removeClusterRequest)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return result, nil
 }
 
 // UpdateNode allows the user to update the information of a node.
@@ -169,7 +293,11 @@ func (h *Handler) UpdateNode(ctx context.Context, request *grpc_infrastructure_g
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	return h.Manager.UpdateNode(request)
+	result, err := h.Manager.UpdateNode(request)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return result, nil
 }
 
 // ListNodes obtains a list of nodes in a cluster.
@@ -181,11 +309,24 @@ func (h *Handler) ListNodes(ctx context.Context, clusterID *grpc_infrastructure_
 	return h.Manager.ListNodes(clusterID)
 }
 
-// RemoveNodes removes a set of nodes from the system.
+// RemoveNodes removes a set of nodes from the system. The request returns once the removal has been launched.
 func (h *Handler) RemoveNodes(ctx context.Context, removeNodesRequest *grpc_infrastructure_go.RemoveNodesRequest) (*grpc_common_go.Success, error) {
 	err := entities.ValidRemoveNodesRequest(removeNodesRequest)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	return h.Manager.RemoveNodes(removeNodesRequest)
+	err = h.Manager.checkNotShuttingDown()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	err = h.Manager.checkLeader()
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	removeNodesRequest.RequestId = uuid.NewV4().String()
+	result, err := h.Manager.RemoveNodes(removeNodesRequest, GetDeleteKubernetesNode(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return result, nil
 }
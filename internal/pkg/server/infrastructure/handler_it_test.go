@@ -34,6 +34,8 @@ import (
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-provisioner-go"
 	"github.com/nalej/grpc-utils/pkg/test"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/nalej/infrastructure-manager/internal/pkg/kubeconfig"
 	"github.com/nalej/infrastructure-manager/internal/pkg/utils"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
@@ -43,6 +45,7 @@ import (
 	"google.golang.org/grpc/test/bufconn"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -116,6 +119,8 @@ var _ = ginkgo.Describe("Infrastructure", func() {
 
 	// Temp dir
 	var tempDir string
+	// Journal dir
+	var journalDir string
 
 	// Target organization.
 	var targetOrganization *grpc_organization_go.Organization
@@ -143,9 +148,16 @@ var _ = ginkgo.Describe("Infrastructure", func() {
 		conn, err := test.GetConn(*listener)
 		gomega.Expect(err).To(gomega.Succeed())
 
+		journalDir, err = ioutil.TempDir("", "handlerITJournal")
+		gomega.Expect(err).To(gomega.Succeed())
+		opJournal, jErr := journal.NewFileJournal(journalDir)
+		gomega.Expect(jErr).To(gomega.Succeed())
+		kubeConfigs, kErr := kubeconfig.NewFileStore(filepath.Join(journalDir, "kubeconfig"))
+		gomega.Expect(kErr).To(gomega.Succeed())
+
 		manager := NewManager(tempDir, clusterClient, nodesClient, installerClient, provisionerClient, scaleClient,
-			managementClient, decommissionClient, appClient, nil)
-		handler := NewHandler(manager)
+			managementClient, decommissionClient, appClient, orgClient, nil, nil, opJournal, kubeConfigs, OperationConfig{})
+		handler := NewHandler(manager, DefaultIdempotencyKeyRetention, nil)
 		grpc_infrastructure_manager_go.RegisterInfrastructureManagerServer(server, handler)
 		test.LaunchServer(server, listener)
 
@@ -167,6 +179,8 @@ var _ = ginkgo.Describe("Infrastructure", func() {
 		_ = smConn.Close()
 		err := os.RemoveAll(tempDir)
 		gomega.Expect(err).To(gomega.Succeed())
+		err = os.RemoveAll(journalDir)
+		gomega.Expect(err).To(gomega.Succeed())
 	})
 
 	ginkgo.Context("with an existing kubernetes cluster", func() {
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/metadata"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the gRPC metadata key where clients send the idempotency key of a request.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// DefaultIdempotencyKeyRetention is the default time an idempotency key is remembered.
+const DefaultIdempotencyKeyRetention = time.Hour * 24
+
+// idempotencyEntry contains the result of a request associated with an idempotency key.
+type idempotencyEntry struct {
+	// done is closed once the result is available.
+	done chan struct{}
+	// digest identifies the payload of the request that used the key.
+	digest   string
+	response interface{}
+	err      error
+	expires  time.Time
+}
+
+// IdempotencyCache remembers the responses of the requests sent with an idempotency key so that retries of the
+// same request return the original response instead of starting a new operation.
+type IdempotencyCache struct {
+	sync.Mutex
+	retention time.Duration
+	entries   map[string]*idempotencyEntry
+	// store persists the keys so that they survive restarts and leader changes. It is nil if the keys are only
+	// kept in memory.
+	store journal.KeyStore
+	// responses creates an empty response of each persisted operation to decode the stored ones.
+	responses map[string]func() interface{}
+}
+
+// NewIdempotencyCache creates a cache that retains the keys for a given period of time.
+func NewIdempotencyCache(retention time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		retention: retention,
+		entries:   make(map[string]*idempotencyEntry, 0),
+	}
+}
+
+// Persist stores the keys of the given operations in a key store. The responses map creates an empty response of
+// each operation so that the stored ones can be decoded.
+func (c *IdempotencyCache) Persist(store journal.KeyStore, responses map[string]func() interface{}) {
+	c.store = store
+	c.responses = responses
+}
+
+// GetIdempotencyKey retrieves the idempotency key sent by the client, if any.
+func GetIdempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(IdempotencyKeyHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// requestDigest returns the digest of the payload of a request, so that a key cannot be reused with a different
+// payload.
+func requestDigest(payload interface{}) string {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot marshal request with idempotency key")
+		return ""
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// keyReused returns the error of a request that uses a key already used with a different payload.
+func keyReused(key string) error {
+	return conversions.ToGRPCError(derrors.NewInvalidArgumentError("idempotency key already used with a different request").WithParams(key))
+}
+
+// Execute runs a request unless another request with the same key has been executed by the organization, in which
+// case the original response is returned. Concurrent requests with the same key wait for the first one to finish.
+// Failed requests are not retained so that the client can retry them. Keys not found in memory are looked up in the
+// key store, if any. The payload must be the same on every request with the same key, otherwise an InvalidArgument
+// error is returned.
+func (c *IdempotencyCache) Execute(organizationID string, operation string, key string, payload interface{}, request func() (interface{}, error)) (interface{}, error) {
+	if key == "" {
+		return request()
+	}
+	cacheKey := fmt.Sprintf("%s/%s/%s", organizationID, operation, key)
+	digest := requestDigest(payload)
+
+	c.Lock()
+	c.purgeExpired()
+	entry, exists := c.entries[cacheKey]
+	if !exists {
+		entry = &idempotencyEntry{done: make(chan struct{}), digest: digest}
+		c.entries[cacheKey] = entry
+	}
+	c.Unlock()
+
+	if exists {
+		if entry.digest != digest {
+			return nil, keyReused(key)
+		}
+		<-entry.done
+		log.Debug().Str("organizationID", organizationID).Str("operation", operation).
+			Str("idempotencyKey", key).Msg("returning response of a previous request")
+		return entry.response, entry.err
+	}
+
+	finished := false
+	expires := time.Now().Add(c.retention)
+	defer func() {
+		c.complete(cacheKey, entry, finished, expires)
+	}()
+	stored, found := c.load(operation, cacheKey)
+	if found && stored.digest != digest {
+		// The key is forgotten from memory so that the stored one is checked again by the next request.
+		entry.err = keyReused(key)
+		finished = true
+		return nil, entry.err
+	}
+	if found {
+		entry.response, expires, finished = stored.response, stored.expires, true
+		log.Debug().Str("organizationID", organizationID).Str("operation", operation).
+			Str("idempotencyKey", key).Msg("returning stored response of a previous request")
+		return entry.response, nil
+	}
+	entry.response, entry.err = request()
+	finished = true
+	if entry.err == nil {
+		c.save(operation, cacheKey, digest, entry.response, expires)
+	}
+	return entry.response, entry.err
+}
+
+// complete makes the result of a request available to the requests waiting for the same key. Failed requests, and
+// requests that did not finish because of a panic, are forgotten so that the client can retry them.
+func (c *IdempotencyCache) complete(cacheKey string, entry *idempotencyEntry, finished bool, expires time.Time) {
+	c.Lock()
+	if !finished {
+		entry.response = nil
+		entry.err = conversions.ToGRPCError(derrors.NewInternalError("request with idempotency key did not complete"))
+	}
+	if entry.err != nil {
+		delete(c.entries, cacheKey)
+	} else {
+		entry.expires = expires
+	}
+	c.Unlock()
+	close(entry.done)
+}
+
+// storedKey contains a key retrieved from the key store.
+type storedKey struct {
+	// digest identifies the payload of the request.
+	digest   string
+	response interface{}
+	expires  time.Time
+}
+
+// load retrieves the response of a key and its expiration from the key store. It returns false if the key is not
+// stored.
+func (c *IdempotencyCache) load(operation string, cacheKey string) (*storedKey, bool) {
+	newResponse, persisted := c.responses[operation]
+	if c.store == nil || !persisted {
+		return nil, false
+	}
+	record, err := c.store.Get(cacheKey)
+	if err != nil {
+		if err.Type() != derrors.NotFound {
+			log.Warn().Str("key", cacheKey).Str("trace", err.DebugReport()).Msg("cannot retrieve idempotency key")
+		}
+		return nil, false
+	}
+	response := newResponse()
+	uErr := json.Unmarshal(record.Response, response)
+	if uErr != nil {
+		log.Warn().Str("key", cacheKey).Err(uErr).Msg("cannot unmarshal stored response")
+		return nil, false
+	}
+	return &storedKey{digest: record.Digest, response: response, expires: time.Unix(record.Expires, 0)}, true
+}
+
+// save stores the response of a key in the key store, if any.
+func (c *IdempotencyCache) save(operation string, cacheKey string, digest string, response interface{}, expires time.Time) {
+	if _, persisted := c.responses[operation]; c.store == nil || !persisted {
+		return
+	}
+	content, err := json.Marshal(response)
+	if err != nil {
+		log.Warn().Str("key", cacheKey).Err(err).Msg("cannot marshal response of idempotency key")
+		return
+	}
+	sErr := c.store.Put(journal.KeyRecord{
+		Key:      cacheKey,
+		Digest:   digest,
+		Response: content,
+		Expires:  expires.Unix(),
+	})
+	if sErr != nil {
+		log.Warn().Str("key", cacheKey).Str("trace", sErr.DebugReport()).Msg("cannot store idempotency key")
+	}
+}
+
+// purgeExpired removes the keys whose retention period has expired. The lock is expected to be held by the caller.
+func (c *IdempotencyCache) purgeExpired() {
+	now := time.Now()
+	for cacheKey, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, cacheKey)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var _ = ginkgo.Describe("An idempotency cache", func() {
+
+	var cache *IdempotencyCache
+	var executions int
+
+	request := func() (interface{}, error) {
+		executions++
+		return executions, nil
+	}
+
+	ginkgo.BeforeEach(func() {
+		cache = NewIdempotencyCache(time.Minute)
+		executions = 0
+	})
+
+	ginkgo.It("should return the original response for the same key", func() {
+		first, err := cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		second, err := cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(second).Should(gomega.Equal(first))
+		gomega.Expect(executions).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should reject a key used with a different payload", func() {
+		_, err := cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cache.Execute("org", "install", "key", "other", request)
+		gomega.Expect(status.Code(err)).Should(gomega.Equal(codes.InvalidArgument))
+		gomega.Expect(executions).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should execute requests without key or from other organizations", func() {
+		_, err := cache.Execute("org", "install", "", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cache.Execute("org", "install", "", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cache.Execute("otherOrg", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(executions).Should(gomega.Equal(4))
+	})
+
+	ginkgo.It("should not retain failed requests", func() {
+		_, err := cache.Execute("org", "install", "key", "payload", func() (interface{}, error) {
+			executions++
+			return nil, derrors.NewUnavailableError("failed")
+		})
+		gomega.Expect(err).ToNot(gomega.Succeed())
+		_, err = cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(executions).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should release the waiting requests if a request panics", func() {
+		started := make(chan struct{})
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			defer func() {
+				gomega.Expect(recover()).ShouldNot(gomega.BeNil())
+			}()
+			_, _ = cache.Execute("org", "install", "key", "payload", func() (interface{}, error) {
+				close(started)
+				time.Sleep(time.Millisecond * 10)
+				panic("request failed")
+			})
+		}()
+		<-started
+		_, err := cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).ToNot(gomega.Succeed())
+		_, err = cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should return the stored response after a restart", func() {
+		basePath, err := ioutil.TempDir("", "keysTest")
+		gomega.Expect(err).To(gomega.Succeed())
+		defer os.RemoveAll(basePath)
+		store, sErr := journal.NewFileKeyStore(basePath)
+		gomega.Expect(sErr).To(gomega.Succeed())
+		responses := map[string]func() interface{}{
+			"install": func() interface{} { return &grpc_common_go.OpResponse{} },
+		}
+		cache.Persist(store, responses)
+		install := func() (interface{}, error) {
+			executions++
+			return &grpc_common_go.OpResponse{RequestId: "request"}, nil
+		}
+		_, err = cache.Execute("org", "install", "key", "payload", install)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		restarted := NewIdempotencyCache(time.Minute)
+		restarted.Persist(store, responses)
+		response, err := restarted.Execute("org", "install", "key", "payload", install)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.(*grpc_common_go.OpResponse).RequestId).Should(gomega.Equal("request"))
+		gomega.Expect(executions).Should(gomega.Equal(1))
+
+		restarted = NewIdempotencyCache(time.Minute)
+		restarted.Persist(store, responses)
+		_, err = restarted.Execute("org", "install", "key", "other", install)
+		gomega.Expect(status.Code(err)).Should(gomega.Equal(codes.InvalidArgument))
+		gomega.Expect(executions).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should forget keys once the retention period expires", func() {
+		cache = NewIdempotencyCache(time.Millisecond)
+		_, err := cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		time.Sleep(time.Millisecond * 10)
+		_, err = cache.Execute("org", "install", "key", "payload", request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(executions).Should(gomega.Equal(2))
+	})
+})
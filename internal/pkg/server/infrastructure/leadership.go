@@ -0,0 +1,46 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+)
+
+// Leadership provides the result of the leader election among the replicas of the infrastructure manager.
+type Leadership interface {
+	// IsLeader returns true if this replica is the current leader.
+	IsLeader() bool
+	// GetLeader returns the identity of the current leader.
+	GetLeader() string
+}
+
+// SetLeadership enables the leader election. Once set, only the leader accepts operations and runs their monitors,
+// while the rest of the replicas serve the read-only requests. It must be called before the manager is passed to
+// the handler.
+func (m *Manager) SetLeadership(leadership Leadership) {
+	m.leadership = leadership
+}
+
+// checkLeader returns an Unavailable error if the leader election is enabled and this replica is not the leader so
+// that clients retry the request on the leader.
+func (m *Manager) checkLeader() derrors.Error {
+	if m.leadership == nil || m.leadership.IsLeader() {
+		return nil
+	}
+	return derrors.NewUnavailableError("this infrastructure manager replica is not the leader, retry the request").
+		WithParams(m.leadership.GetLeader())
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// clusterLock contains the operation that holds the lock of a cluster.
+type clusterLock struct {
+	requestID     string
+	operationType entities.OperationType
+}
+
+// ClusterLocks guarantees that only one operation is executed on a cluster at a given time. Locks are held by a
+// request identifier so that chained operations such as provision and install, or uninstall and decommission, keep
+// the lock until the last step finishes.
+type ClusterLocks struct {
+	sync.Mutex
+	locks map[string]clusterLock
+}
+
+// NewClusterLocks creates an empty set of locks.
+func NewClusterLocks() *ClusterLocks {
+	return &ClusterLocks{
+		locks: make(map[string]clusterLock, 0),
+	}
+}
+
+// lockKey returns the key associated with a cluster.
+func lockKey(organizationID string, clusterID string) string {
+	return fmt.Sprintf("%s/%s", organizationID, clusterID)
+}
+
+// Acquire obtains the lock of a cluster for a given request. Acquiring a lock already held by the same request
+// succeeds. If another request holds the lock, a FailedPrecondition error naming the conflicting operation is returned.
+func (cl *ClusterLocks) Acquire(organizationID string, clusterID string, requestID string, operationType entities.OperationType) derrors.Error {
+	cl.Lock()
+	defer cl.Unlock()
+	key := lockKey(organizationID, clusterID)
+	current, exists := cl.locks[key]
+	if exists && current.requestID != requestID {
+		return derrors.NewFailedPreconditionError(
+			fmt.Sprintf("cluster has an ongoing %s operation with request_id %s", current.operationType, current.requestID)).
+			WithParams(clusterID, current.requestID, current.operationType)
+	}
+	cl.locks[key] = clusterLock{requestID: requestID, operationType: operationType}
+	log.Debug().Str("clusterID", clusterID).Str("requestID", requestID).Str("type", string(operationType)).Msg("cluster lock acquired")
+	return nil
+}
+
+// Holder returns the request and the operation that hold the lock of a cluster, if any.
+func (cl *ClusterLocks) Holder(organizationID string, clusterID string) (string, entities.OperationType, bool) {
+	cl.Lock()
+	defer cl.Unlock()
+	current, exists := cl.locks[lockKey(organizationID, clusterID)]
+	return current.requestID, current.operationType, exists
+}
+
+// Release frees the lock of a cluster if it is held by the given request.
+func (cl *ClusterLocks) Release(organizationID string, clusterID string, requestID string) {
+	cl.Lock()
+	defer cl.Unlock()
+	key := lockKey(organizationID, clusterID)
+	current, exists := cl.locks[key]
+	if !exists || current.requestID != requestID {
+		return
+	}
+	delete(cl.locks, key)
+	log.Debug().Str("clusterID", clusterID).Str("requestID", requestID).Msg("cluster lock released")
+}
+
+// OrganizationLocks serializes the quota checks of each organization, so that the usage of an organization cannot
+// change between the check of a request and the registration of its operation.
+type OrganizationLocks struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewOrganizationLocks creates an empty set of organization locks.
+func NewOrganizationLocks() *OrganizationLocks {
+	return &OrganizationLocks{
+		locks: make(map[string]*sync.Mutex, 0),
+	}
+}
+
+// Acquire blocks until the lock of an organization is obtained, and returns the function that releases it.
+func (ol *OrganizationLocks) Acquire(organizationID string) func() {
+	ol.Lock()
+	lock, exists := ol.locks[organizationID]
+	if !exists {
+		lock = &sync.Mutex{}
+		ol.locks[organizationID] = lock
+	}
+	ol.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
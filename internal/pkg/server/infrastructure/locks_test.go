@@ -0,0 +1,80 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Cluster locks", func() {
+
+	var locks *ClusterLocks
+
+	ginkgo.BeforeEach(func() {
+		locks = NewClusterLocks()
+	})
+
+	ginkgo.It("should reject operations from other requests", func() {
+		gomega.Expect(locks.Acquire("org", "cluster", "r1", entities.ScaleOperation)).To(gomega.Succeed())
+		err := locks.Acquire("org", "cluster", "r2", entities.UninstallOperation)
+		gomega.Expect(err).ToNot(gomega.BeNil())
+		gomega.Expect(err.Error()).Should(gomega.ContainSubstring("r1"))
+		gomega.Expect(locks.Acquire("org", "otherCluster", "r2", entities.UninstallOperation)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should allow chained operations of the same request", func() {
+		gomega.Expect(locks.Acquire("org", "cluster", "r1", entities.ProvisionOperation)).To(gomega.Succeed())
+		gomega.Expect(locks.Acquire("org", "cluster", "r1", entities.InstallOperation)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should only be released by the holder", func() {
+		gomega.Expect(locks.Acquire("org", "cluster", "r1", entities.ScaleOperation)).To(gomega.Succeed())
+		locks.Release("org", "cluster", "r2")
+		gomega.Expect(locks.Acquire("org", "cluster", "r2", entities.ScaleOperation)).ToNot(gomega.Succeed())
+		locks.Release("org", "cluster", "r1")
+		gomega.Expect(locks.Acquire("org", "cluster", "r2", entities.ScaleOperation)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should report the holder of a lock", func() {
+		_, _, locked := locks.Holder("org", "cluster")
+		gomega.Expect(locked).Should(gomega.BeFalse())
+		gomega.Expect(locks.Acquire("org", "cluster", "r1", entities.UninstallOperation)).To(gomega.Succeed())
+		requestID, operationType, locked := locks.Holder("org", "cluster")
+		gomega.Expect(locked).Should(gomega.BeTrue())
+		gomega.Expect(requestID).Should(gomega.Equal("r1"))
+		gomega.Expect(operationType).Should(gomega.Equal(entities.UninstallOperation))
+	})
+})
+
+var _ = ginkgo.Describe("Organization locks", func() {
+
+	ginkgo.It("should block other requests of the same organization until released", func() {
+		locks := NewOrganizationLocks()
+		release := locks.Acquire("org")
+		locks.Acquire("otherOrg")()
+		acquired := make(chan bool)
+		go func() {
+			locks.Acquire("org")()
+			acquired <- true
+		}()
+		gomega.Consistently(acquired).ShouldNot(gomega.Receive())
+		release()
+		gomega.Eventually(acquired).Should(gomega.Receive())
+	})
+})
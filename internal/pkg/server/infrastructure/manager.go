@@ -33,7 +33,10 @@ import (
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/nalej/infrastructure-manager/internal/pkg/bus"
 	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/nalej/infrastructure-manager/internal/pkg/kubeconfig"
 	"github.com/nalej/infrastructure-manager/internal/pkg/monitor"
+	"github.com/nalej/infrastructure-manager/internal/pkg/quota"
 	"github.com/nalej/infrastructure-manager/internal/pkg/server/discovery/k8s"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
@@ -59,7 +62,19 @@ type Manager struct {
 	managementClient   grpc_provisioner_go.ManagementClient
 	decommissionClient grpc_provisioner_go.DecommissionClient
 	appClient          grpc_application_go.ApplicationsClient
-	busManager         *bus.BusManager
+	orgClient          grpc_organization_go.OrganizationsClient
+	busManager         bus.Sender
+	progressConsumer   *bus.ProgressConsumer
+	journal            journal.Journal
+	kubeConfigs        kubeconfig.Store
+	operations         *OperationRegistry
+	clusterLocks       *ClusterLocks
+	quotaLocks         *OrganizationLocks
+	operationConfig    OperationConfig
+	shutdown           *shutdownState
+	// leadership is nil unless the leader election is enabled.
+	leadership Leadership
+	quotas     *quota.Quotas
 }
 
 // NewManager creates a new manager.
@@ -73,7 +88,12 @@ func NewManager(
 	managementClient grpc_provisioner_go.ManagementClient,
 	decommissionClient grpc_provisioner_go.DecommissionClient,
 	appClient grpc_application_go.ApplicationsClient,
-	busManager *bus.BusManager) Manager {
+	orgClient grpc_organization_go.OrganizationsClient,
+	busManager bus.Sender,
+	progressConsumer *bus.ProgressConsumer,
+	journal journal.Journal,
+	kubeConfigs kubeconfig.Store,
+	operationConfig OperationConfig) Manager {
 	return Manager{
 		tempPath:           tempDir,
 		clusterClient:      clusterClient,
@@ -84,7 +104,17 @@ func NewManager(
 		managementClient:   managementClient,
 		decommissionClient: decommissionClient,
 		appClient:          appClient,
+		orgClient:          orgClient,
 		busManager:         busManager,
+		progressConsumer:   progressConsumer,
+		journal:            journal,
+		kubeConfigs:        kubeConfigs,
+		operations:         NewOperationRegistry(),
+		clusterLocks:       NewClusterLocks(),
+		quotaLocks:         NewOrganizationLocks(),
+		operationConfig:    operationConfig,
+		shutdown:           newShutdownState(),
+		quotas:             quota.Unlimited(),
 	}
 }
 
@@ -106,7 +136,9 @@ func (m *Manager) writeTempFile(content string, prefix string) (*string, derrors
 	return &tmpName, nil
 }
 
-func (m *Manager) attachNodes(requestID string, organizationID string, clusterID string, cluster *entities.Cluster) derrors.Error {
+// attachNodes adds the nodes of a cluster to system model and attaches them to the cluster. The removal of each added
+// node is registered in the compensation of the caller.
+func (m *Manager) attachNodes(requestID string, organizationID string, clusterID string, cluster *entities.Cluster, compensation *Compensation) derrors.Error {
 	for _, n := range cluster.Nodes {
 		nodeToAdd := &grpc_infrastructure_go.AddNodeRequest{
 			RequestId:      requestID,
@@ -119,6 +151,10 @@ func (m *Manager) attachNodes(requestID string, organizationID string, clusterID
 		if err != nil {
 			return conversions.ToDerror(err)
 		}
+		nodeID := addedNode.NodeId
+		compensation.Add(fmt.Sprintf("add node %s", nodeID), func() derrors.Error {
+			return m.removeNodeRecord(requestID, organizationID, nodeID)
+		})
 		attachReq := &grpc_infrastructure_go.AttachNodeRequest{
 			RequestId:      requestID,
 			OrganizationId: organizationID,
@@ -134,8 +170,9 @@ func (m *Manager) attachNodes(requestID string, organizationID string, clusterID
 	return nil
 }
 
-// addClusterToSM adds the newly discovered cluster to the system model.
-func (m *Manager) addClusterToSM(requestID string, organizationID string, cluster entities.Cluster, clusterState grpc_infrastructure_go.ClusterState) (*grpc_infrastructure_go.Cluster, derrors.Error) {
+// addClusterToSM adds the newly discovered cluster to the system model labeled with its origin. If any step fails,
+// the records already added are removed.
+func (m *Manager) addClusterToSM(requestID string, organizationID string, cluster entities.Cluster, clusterState grpc_infrastructure_go.ClusterState, origin string) (*grpc_infrastructure_go.Cluster, derrors.Error) {
 	toAdd := &grpc_infrastructure_go.AddClusterRequest{
 		RequestId:            requestID,
 		OrganizationId:       organizationID,
@@ -148,15 +185,23 @@ func (m *Manager) addClusterToSM(requestID string, organizationID string, cluste
 	if err != nil {
 		return nil, conversions.ToDerror(err)
 	}
-	err = m.updateClusterState(organizationID, clusterAdded.ClusterId, clusterState)
-	if err != nil {
-		return nil, conversions.ToDerror(err)
+	compensation := NewCompensation(requestID)
+	compensation.Add(fmt.Sprintf("add cluster %s", clusterAdded.ClusterId), func() derrors.Error {
+		return m.removeClusterRecord(requestID, organizationID, clusterAdded.ClusterId)
+	})
+	sErr := m.updateClusterState(entities.RegisterOperation, organizationID, clusterAdded.ClusterId, clusterState)
+	if sErr != nil {
+		return nil, m.revertRegistration(compensation, organizationID, clusterAdded.ClusterId, sErr)
+	}
+	oErr := m.labelOrigin(organizationID, clusterAdded.ClusterId, origin)
+	if oErr != nil {
+		return nil, m.revertRegistration(compensation, organizationID, clusterAdded.ClusterId, oErr)
 	}
 
 	// add and attach nodes
-	attErr := m.attachNodes(requestID, organizationID, clusterAdded.ClusterId, &cluster)
+	attErr := m.attachNodes(requestID, organizationID, clusterAdded.ClusterId, &cluster, compensation)
 	if attErr != nil {
-		return nil, attErr
+		return nil, m.revertRegistration(compensation, organizationID, clusterAdded.ClusterId, attErr)
 	}
 
 	// Retrieve the cluster from system model so that it contains up-to-date information as required by the calling
@@ -169,7 +214,7 @@ func (m *Manager) addClusterToSM(requestID string, organizationID string, cluste
 	}
 	result, err := m.clusterClient.GetCluster(ctx, clusterID)
 	if err != nil {
-		return nil, conversions.ToDerror(err)
+		return nil, m.revertRegistration(compensation, organizationID, clusterAdded.ClusterId, conversions.ToDerror(err))
 	}
 	return result, nil
 }
@@ -258,10 +303,21 @@ func (m *Manager) getOrCreateProvisionedCluster(installRequest *grpc_installer_g
 		if err != nil {
 			return nil, err
 		}
-		added, err := m.addClusterToSM(installRequest.RequestId, installRequest.OrganizationId, *discovered, grpc_infrastructure_go.ClusterState_PROVISIONED)
+		added, err := m.addClusterToSM(installRequest.RequestId, installRequest.OrganizationId, *discovered, grpc_infrastructure_go.ClusterState_PROVISIONED, ImportedOrigin)
 		if err != nil {
 			return nil, err
 		}
+		// The kubeconfig of imported clusters is kept so that they can be removed later on, so the cluster is not
+		// installed if it cannot be stored.
+		err = m.kubeConfigs.Put(installRequest.OrganizationId, added.ClusterId, installRequest.KubeConfigRaw)
+		if err != nil {
+			log.Error().Str("clusterID", added.ClusterId).Str("trace", err.DebugReport()).Msg("cannot store the kubeconfig of the imported cluster")
+			compensation := NewCompensation(installRequest.RequestId)
+			compensation.Add(fmt.Sprintf("add cluster %s", added.ClusterId), func() derrors.Error {
+				return m.removeClusterFromSM(installRequest.RequestId, installRequest.OrganizationId, added.ClusterId)
+			})
+			return nil, m.revertRegistration(compensation, installRequest.OrganizationId, added.ClusterId, err)
+		}
 		result = added
 	} else {
 		retrieved, err := m.getCluster(installRequest.OrganizationId, installRequest.ClusterId)
@@ -294,8 +350,24 @@ func (m *Manager) getCluster(organizationID string, clusterID string) (*grpc_inf
 }
 
 // UpdateClusterState updates the state of a cluster in system model. The update is also sent to the bus
-// so that other components of the system can react to events such as new cluster becoming available.
-func (m *Manager) updateClusterState(organizationID string, clusterID string, newState grpc_infrastructure_go.ClusterState) derrors.Error {
+// so that other components of the system can react to events such as new cluster becoming available. The
+// transition is validated against the cluster lifecycle of the operation that performs it.
+func (m *Manager) updateClusterState(operation entities.OperationType, organizationID string, clusterID string, newState grpc_infrastructure_go.ClusterState) derrors.Error {
+	// Newly registered clusters do not have a previous state to check.
+	var current grpc_infrastructure_go.ClusterState
+	if operation != entities.RegisterOperation {
+		retrieved, err := m.getCluster(organizationID, clusterID)
+		if err != nil {
+			return err
+		}
+		current = retrieved.State
+	}
+	vErr := entities.ValidClusterTransition(operation, current, newState)
+	if vErr != nil {
+		log.Warn().Str("clusterID", clusterID).Str("operation", string(operation)).
+			Str("from", current.String()).Str("to", newState.String()).Msg("illegal cluster state transition")
+		return vErr
+	}
 	updateRequest := &grpc_infrastructure_go.UpdateClusterRequest{
 		OrganizationId:     organizationID,
 		ClusterId:          clusterID,
@@ -322,6 +394,18 @@ func (m *Manager) updateClusterState(organizationID string, clusterID string, ne
 	return nil
 }
 
+// sendOperationEvent publishes on the bus the result of an operation that has been finished by the infrastructure
+// manager instead of the remote component, for example when its deadline is reached.
+func (m *Manager) sendOperationEvent(event *grpc_common_go.OpResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	errBus := m.busManager.SendEvents(ctx, event)
+	if errBus != nil {
+		log.Error().Str("requestID", event.RequestId).Str("trace", errBus.DebugReport()).
+			Msg("error in the bus when sending an operation event")
+	}
+}
+
 // ProvisionAndInstallCluster provisions a new kubernetes cluster and then installs it
 func (m *Manager) ProvisionAndInstallCluster(provisionRequest *grpc_provisioner_go.ProvisionClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, error) {
 	log.Debug().Str("organizationID", provisionRequest.OrganizationId).
@@ -334,15 +418,20 @@ func (m *Manager) ProvisionAndInstallCluster(provisionRequest *grpc_provisioner_
 		KubernetesVersion: provisionRequest.KubernetesVersion,
 	}
 
-	cluster, err := m.addClusterToSM(provisionRequest.RequestId, provisionRequest.OrganizationId, toAdd, grpc_infrastructure_go.ClusterState_PROVISIONING)
+	cluster, err := m.addClusterToSM(provisionRequest.RequestId, provisionRequest.OrganizationId, toAdd, grpc_infrastructure_go.ClusterState_PROVISIONING, ProvisionedOrigin)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
 	provisionRequest.ClusterId = cluster.ClusterId
+	err = m.clusterLocks.Acquire(provisionRequest.OrganizationId, provisionRequest.ClusterId, provisionRequest.RequestId, entities.ProvisionOperation)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
 
 	log.Debug().Str("clusterID", provisionRequest.ClusterId).Msg("provisioning cluster")
 	provisionerResponse, pErr := m.provisionerClient.ProvisionCluster(context.Background(), provisionRequest)
 	if pErr != nil {
+		m.clusterLocks.Release(provisionRequest.OrganizationId, provisionRequest.ClusterId, provisionRequest.RequestId)
 		return nil, pErr
 	}
 	log.Debug().Str("clusterID", provisionRequest.ClusterId).Msg("cluster is being provisioned")
@@ -353,12 +442,25 @@ func (m *Manager) ProvisionAndInstallCluster(provisionRequest *grpc_provisioner_
 		State:          provisionerResponse.State,
 		Error:          provisionerResponse.Error,
 	}
-	mon := monitor.NewProvisionerMonitor(m.provisionerClient, m.clusterClient, *provisionResponse)
-	mon.RegisterCallback(m.provisionCallback)
-	go mon.LaunchMonitor()
+	m.startOperation(entities.Operation{
+		RequestID:      provisionResponse.RequestId,
+		OrganizationID: provisionResponse.OrganizationId,
+		ClusterID:      provisionResponse.ClusterId,
+		Type:           entities.ProvisionOperation,
+		Platform:       provisionRequest.TargetPlatform,
+		Nodes:          provisionRequest.NumNodes,
+	})
+	go m.monitorProvision(*provisionResponse)
 	return provisionResponse, nil
 }
 
+// monitorProvision follows a provision operation until it finishes.
+func (m *Manager) monitorProvision(provisionResponse grpc_infrastructure_manager_go.ProvisionerResponse) {
+	mon := monitor.NewProvisionerMonitor(m.provisionerClient, m.clusterClient, provisionResponse)
+	mon.RegisterCallback(m.provisionCallback)
+	m.runMonitor(mon, provisionResponse.RequestId, entities.ProvisionOperation)
+}
+
 // provisionCallback function that will be called once a provision operation is finished. If successful, it
 // will trigger the installation of the platform.
 func (m *Manager) provisionCallback(requestID string, organizationID string, clusterID string,
@@ -369,7 +471,7 @@ func (m *Manager) provisionCallback(requestID string, organizationID string, clu
 	if err != nil {
 		log.Error().Str("err", err.DebugReport()).Msg("error callback received")
 	}
-	if lastResponse == nil {
+	if lastResponse == nil && err == nil {
 		return
 	}
 
@@ -378,22 +480,30 @@ func (m *Manager) provisionCallback(requestID string, organizationID string, clu
 		newState = grpc_infrastructure_go.ClusterState_FAILURE
 		log.Warn().Str("requestID", requestID).Str("organizationID", organizationID).Str("clusterID", clusterID).Msg("Provision failed")
 	}
-	err = m.updateClusterState(organizationID, clusterID, newState)
-	if err != nil {
-		log.Error().Msg("unable to update cluster state after provision")
-		return
-	}
 	if newState == grpc_infrastructure_go.ClusterState_FAILURE {
+		err = m.updateClusterState(entities.ProvisionOperation, organizationID, clusterID, newState)
+		if err != nil {
+			log.Error().Msg("unable to update cluster state after provision")
+			return
+		}
 		// The provisioning operation failed, so we should not continue with the install
 		log.Error().Msg("install will not be triggered as provisioning failed")
 		return
 	}
 
+	// The cluster is only marked as provisioned once its nodes are registered, so that a failure while registering
+	// them can be reverted and reported as a failed provision.
+	compensation := NewCompensation(requestID)
 	discovered, err := m.discoverCluster(requestID, lastResponse.RawKubeConfig, lastResponse.Hostname)
 	if err != nil {
-		log.Error().Msg("unable to discover cluster")
+		m.provisionRegistrationFailed(requestID, organizationID, clusterID, compensation, err)
 		return
 	}
+	// The kubeconfig is kept so that the nodes of the cluster can be managed without asking the provisioner.
+	kErr := m.kubeConfigs.Put(organizationID, clusterID, lastResponse.RawKubeConfig)
+	if kErr != nil {
+		log.Warn().Str("clusterID", clusterID).Str("trace", kErr.DebugReport()).Msg("cannot store the kubeconfig of the provisioned cluster")
+	}
 	clusterUpdate := &grpc_infrastructure_go.UpdateClusterRequest{
 		OrganizationId:             organizationID,
 		ClusterId:                  clusterID,
@@ -408,10 +518,16 @@ func (m *Manager) provisionCallback(requestID string, organizationID string, clu
 	}
 
 	// create the nodes and attach the to the cluster
-	attErr := m.attachNodes(requestID, organizationID, clusterID, discovered )
+	attErr := m.attachNodes(requestID, organizationID, clusterID, discovered, compensation)
 	if attErr != nil {
-		// TODO: What to do??
-		log.Error().Str("trace", attErr.DebugReport()).Msg("error attaching nodes")
+		m.provisionRegistrationFailed(requestID, organizationID, clusterID, compensation, attErr)
+		return
+	}
+	err = m.updateClusterState(entities.ProvisionOperation, organizationID, clusterID, newState)
+	if err != nil {
+		log.Error().Msg("unable to update cluster state after provision")
+		m.provisionRegistrationFailed(requestID, organizationID, clusterID, compensation, err)
+		return
 	}
 
 	installRequest := &grpc_installer_go.InstallRequest{
@@ -422,7 +538,7 @@ func (m *Manager) provisionCallback(requestID string, organizationID string, clu
 		InstallBaseSystem: false,
 		KubeConfigRaw:     lastResponse.RawKubeConfig,
 		Hostname:          lastResponse.Hostname,
-		TargetPlatform:    grpc_installer_go.Platform_AZURE,
+		TargetPlatform:    m.provisionedPlatform(requestID),
 		StaticIpAddresses: lastResponse.StaticIpAddresses,
 	}
 	_, icErr := m.InstallCluster(installRequest)
@@ -432,38 +548,89 @@ func (m *Manager) provisionCallback(requestID string, organizationID string, clu
 	return
 }
 
+// provisionedPlatform returns the target platform of the provision of a request, so that the cluster is installed
+// on the same platform. Provisions journaled before the platform was recorded target Azure.
+func (m *Manager) provisionedPlatform(requestID string) grpc_installer_go.Platform {
+	op, err := m.operations.Get(requestID)
+	if err != nil {
+		log.Warn().Str("requestID", requestID).Msg("provision not found, installing on Azure")
+		return grpc_installer_go.Platform_AZURE
+	}
+	return op.Platform
+}
+
 func (m *Manager) InstallCluster(request *grpc_installer_go.InstallRequest) (*grpc_common_go.OpResponse, error) {
 	log.Debug().Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).
 		Str("platform", request.TargetPlatform.String()).
 		Str("hostname", request.Hostname).Msg("InstallCluster")
+	if request.InstallBaseSystem {
+		return nil, derrors.NewUnimplementedError("InstallBaseSystem not supported")
+	}
+	// Existing clusters are locked before checking their state. Discovered clusters are locked once they are added
+	// to system model.
+	if request.ClusterId != "" {
+		lErr := m.clusterLocks.Acquire(request.OrganizationId, request.ClusterId, request.RequestId, entities.InstallOperation)
+		if lErr != nil {
+			return nil, conversions.ToGRPCError(lErr)
+		}
+	}
+	launched := false
+	defer func() {
+		if !launched && request.ClusterId != "" {
+			m.clusterLocks.Release(request.OrganizationId, request.ClusterId, request.RequestId)
+		}
+	}()
 	cluster, err := m.getOrCreateProvisionedCluster(request)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	if request.InstallBaseSystem {
-		return nil, derrors.NewUnimplementedError("InstallBaseSystem not supported")
+	if request.ClusterId == "" {
+		request.ClusterId = cluster.ClusterId
+		err = m.clusterLocks.Acquire(request.OrganizationId, request.ClusterId, request.RequestId, entities.InstallOperation)
+		if err != nil {
+			return nil, conversions.ToGRPCError(err)
+		}
 	}
-	request.ClusterId = cluster.ClusterId
-	if cluster.State != grpc_infrastructure_go.ClusterState_PROVISIONED {
-		return nil, derrors.NewInvalidArgumentError("selected cluster is not ready for install")
+	err = entities.ValidClusterTransition(entities.InstallOperation, cluster.State, grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
 	}
-	err = m.updateClusterState(request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS)
+	err = m.updateClusterState(entities.InstallOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_INSTALL_IN_PROGRESS)
 	if err != nil {
 		log.Error().Str("trace", err.DebugReport()).Msg("cannot update cluster state")
 		return nil, err
 	}
+	// The operation is registered before contacting the installer so that a failure is finished like any other.
+	m.startOperation(entities.Operation{
+		RequestID:      request.RequestId,
+		OrganizationID: request.OrganizationId,
+		ClusterID:      request.ClusterId,
+		Type:           entities.InstallOperation,
+	})
+	launched = true
 	log.Debug().Str("clusterID", request.ClusterId).Msg("installing cluster")
 	response, iErr := m.installerClient.InstallCluster(context.Background(), request)
 	if iErr != nil {
+		log.Error().Str("requestID", request.RequestId).Str("trace", conversions.ToDerror(iErr).DebugReport()).Msg("cannot launch the install")
+		err = m.updateClusterState(entities.InstallOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_FAILURE)
+		if err != nil {
+			log.Error().Str("trace", err.DebugReport()).Msg("cannot update failed cluster install")
+		}
+		m.finishOperation(request.RequestId, entities.InstallOperation)
 		return nil, iErr
 	}
 	log.Debug().Interface("status", response.Status.String()).Msg("cluster is being installed")
-	mon := monitor.NewInstallerMonitor(request.ClusterId, m.installerClient, m.clusterClient, *response)
-	mon.RegisterCallback(m.installCallback)
-	go mon.LaunchMonitor()
+	go m.monitorInstall(request.ClusterId, *response)
 	return response, nil
 }
 
+// monitorInstall follows an install operation until it finishes.
+func (m *Manager) monitorInstall(clusterID string, response grpc_common_go.OpResponse) {
+	mon := monitor.NewInstallerMonitor(clusterID, m.installerClient, m.clusterClient, response)
+	mon.RegisterCallback(m.installCallback)
+	m.runMonitor(mon, response.RequestId, entities.InstallOperation)
+}
+
 // installCallback function called when a install operation has finished on the installer.
 func (m *Manager) installCallback(
 	requestID string, organizationID string, clusterID string,
@@ -474,7 +641,7 @@ func (m *Manager) installCallback(
 	if err != nil {
 		log.Error().Str("err", err.DebugReport()).Msg("error callback received")
 	}
-	if response == nil {
+	if response == nil && err == nil {
 		return
 	}
 
@@ -482,9 +649,9 @@ func (m *Manager) installCallback(
 	if err != nil || response.Status == grpc_common_go.OpStatus_FAILED {
 		newState = grpc_infrastructure_go.ClusterState_FAILURE
 		log.Warn().Str("requestID", requestID).Str("organizationID", organizationID).
-			Str("clusterID", clusterID).Str("error", response.Error).Msg("installation failed")
+			Str("clusterID", clusterID).Str("error", response.GetError()).Msg("installation failed")
 	}
-	err = m.updateClusterState(organizationID, clusterID, newState)
+	err = m.updateClusterState(entities.InstallOperation, organizationID, clusterID, newState)
 	if err != nil {
 		log.Error().Msg("unable to update cluster state after install")
 	}
@@ -507,7 +674,7 @@ func (m *Manager) installCallback(
 			UpdateStatus:   true,
 			Status:         n.Status,
 			UpdateState:    true,
-			State:          entities.OpStatusToNodeState(response.Status),
+			State:          entities.OpStatusToNodeState(response.GetStatus()),
 		}
 		_, updateErr := m.nodesClient.UpdateNode(context.Background(), updateNodeRequest)
 		if updateErr != nil {
@@ -521,49 +688,101 @@ func (m *Manager) installCallback(
 		Msg("cluster has been installed")
 }
 
-// Scale the number of nodes in the cluster.
-func (m *Manager) Scale(request *grpc_provisioner_go.ScaleClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, derrors.Error) {
+// Scale the number of nodes in the cluster. The nodes removed by a scale down are drained first, and the request is
+// rejected if their application services would not fit in the remaining nodes unless force is set. The request
+// returns once the scale is registered, and the drain and the scale itself are followed in the background.
+func (m *Manager) Scale(request *grpc_provisioner_go.ScaleClusterRequest, force bool) (*grpc_infrastructure_manager_go.ProvisionerResponse, derrors.Error) {
 	log.Debug().Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).
 		Str("platform", request.TargetPlatform.String()).Msg("Scale request")
+	err := m.clusterLocks.Acquire(request.OrganizationId, request.ClusterId, request.RequestId, entities.ScaleOperation)
+	if err != nil {
+		return nil, err
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			m.clusterLocks.Release(request.OrganizationId, request.ClusterId, request.RequestId)
+		}
+	}()
 	// Get the cluster and check the current state
 	retrieved, err := m.getCluster(request.OrganizationId, request.ClusterId)
 	if err != nil {
 		return nil, err
 	}
-	if retrieved.State != grpc_infrastructure_go.ClusterState_INSTALLED {
-		return nil, derrors.NewFailedPreconditionError("cluster should be on installed state")
+	err = entities.ValidClusterTransition(entities.ScaleOperation, retrieved.State, grpc_infrastructure_go.ClusterState_SCALING)
+	if err != nil {
+		return nil, err
+	}
+	drain, err := m.selectScaleDownNodes(request, force)
+	if err != nil {
+		return nil, err
 	}
 	// Update the state to scaling
-	err = m.updateClusterState(request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_SCALING)
+	err = m.updateClusterState(entities.ScaleOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_SCALING)
 	if err != nil {
 		return nil, err
 	}
-	// Send the request to the provisioner component
+	// The operation is registered before contacting the provisioner so that a failure is finished like any other.
+	op := entities.Operation{
+		RequestID:      request.RequestId,
+		OrganizationID: request.OrganizationId,
+		ClusterID:      request.ClusterId,
+		Type:           entities.ScaleOperation,
+		Nodes:          request.NumNodes,
+		Draining:       len(drain) > 0,
+	}
+	m.startOperation(op)
+	launched = true
+	if len(drain) > 0 {
+		go m.scaleDown(op, request, drain, force)
+		return &grpc_infrastructure_manager_go.ProvisionerResponse{
+			RequestId:      request.RequestId,
+			OrganizationId: request.OrganizationId,
+			ClusterId:      request.ClusterId,
+		}, nil
+	}
+	return m.launchScale(op, request)
+}
+
+// launchScale sends a registered scale to the provisioner and follows it. If the provisioner rejects the scale, the
+// cluster is marked as failed, the drained nodes are uncordoned and the operation is finished.
+func (m *Manager) launchScale(op entities.Operation, request *grpc_provisioner_go.ScaleClusterRequest) (*grpc_infrastructure_manager_go.ProvisionerResponse, derrors.Error) {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 	provisionerResponse, pErr := m.scalerClient.ScaleCluster(ctx, request)
 	if pErr != nil {
 		// Update the state to error
-		err = m.updateClusterState(request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_FAILURE)
+		err := m.updateClusterState(entities.ScaleOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_FAILURE)
 		if err != nil {
 			log.Error().Str("trace", err.DebugReport()).Msg("cannot update failed cluster scale")
 		}
+		m.uncordonKeptNodes(op)
+		m.finishOperation(op.RequestID, entities.ScaleOperation)
 		return nil, conversions.ToDerror(pErr)
 	}
+	if op.Draining {
+		op.Draining = false
+		m.updateOperation(op)
+	}
 	log.Debug().Str("clusterID", request.ClusterId).Msg("cluster is being scaled")
 	provisionResponse := &grpc_infrastructure_manager_go.ProvisionerResponse{
-		RequestId:      provisionerResponse.RequestId,
+		RequestId:      op.RequestID,
 		OrganizationId: request.OrganizationId,
 		ClusterId:      request.ClusterId,
 		State:          provisionerResponse.State,
 		Error:          provisionerResponse.Error,
 	}
-	mon := monitor.NewScalerMonitor(m.scalerClient, *provisionResponse)
-	mon.RegisterCallback(m.scaleCallback)
-	go mon.LaunchMonitor()
+	go m.monitorScale(*provisionResponse)
 	return provisionResponse, nil
 }
 
+// monitorScale follows a scale operation until it finishes.
+func (m *Manager) monitorScale(provisionResponse grpc_infrastructure_manager_go.ProvisionerResponse) {
+	mon := monitor.NewScalerMonitor(m.scalerClient, provisionResponse)
+	mon.RegisterCallback(m.scaleCallback)
+	m.runMonitor(mon, provisionResponse.RequestId, entities.ScaleOperation)
+}
+
 // scaleCallback function that will be called once a provision operation is finished.
 func (m *Manager) scaleCallback(requestID string, organizationID string, clusterID string,
 	lastResponse *grpc_provisioner_go.ScaleClusterResponse, err derrors.Error) {
@@ -571,7 +790,7 @@ func (m *Manager) scaleCallback(requestID string, organizationID string, cluster
 	if err != nil {
 		log.Error().Str("err", err.DebugReport()).Msg("error callback received")
 	}
-	if lastResponse == nil {
+	if lastResponse == nil && err == nil {
 		return
 	}
 
@@ -580,10 +799,13 @@ func (m *Manager) scaleCallback(requestID string, organizationID string, cluster
 		newState = grpc_infrastructure_go.ClusterState_FAILURE
 		log.Warn().Str("requestID", requestID).Str("organizationID", organizationID).Str("clusterID", clusterID).Msg("Scaling failed")
 	}
-	err = m.updateClusterState(organizationID, clusterID, newState)
+	err = m.updateClusterState(entities.ScaleOperation, organizationID, clusterID, newState)
 	if err != nil {
 		log.Error().Msg("unable to update cluster state after scale")
 	}
+	if op, gErr := m.operations.Get(requestID); gErr == nil {
+		m.uncordonKeptNodes(*op)
+	}
 }
 
 // GetCluster retrieves the cluster information.
@@ -675,24 +897,16 @@ func (m *Manager) UncordonCluster(clusterID *grpc_infrastructure_go.ClusterId) (
 	return succ, err
 }
 
-// RemoveCluster removes a cluster from an organization. Notice that removing a cluster implies draining the cluster
-// of running applications.
-func (m *Manager) RemoveCluster(removeClusterRequest *grpc_infrastructure_go.RemoveClusterRequest) (*grpc_common_go.Success, error) {
-	return nil, derrors.NewUnimplementedError("RemoveCluster is not implemented yet")
-}
-
 // UpdateNode allows the user to update the information of a node.
-func (m *Manager) UpdateNode(request *grpc_infrastructure_go.UpdateNodeRequest) (*grpc_infrastructure_go.Node, error) {
-	updated, err := m.nodesClient.UpdateNode(context.Background(), request)
-	if err != nil {
-		return nil, err
+func (m *Manager) UpdateNode(request *grpc_infrastructure_go.UpdateNodeRequest) (*grpc_infrastructure_go.Node, derrors.Error) {
+	if !request.AddLabels && !request.RemoveLabels {
+		updated, err := m.nodesClient.UpdateNode(context.Background(), request)
+		if err != nil {
+			return nil, conversions.ToDerror(err)
+		}
+		return updated, nil
 	}
-	// TODO Update the labels in Kubernetes. A new proto should be added in the app cluster api to pass that information
-	log.Warn().Str("organizationId", updated.OrganizationId).
-		Str("nodeId", updated.NodeId).
-		Str("clusterId", updated.ClusterId).
-		Msg("node labels have not been updated in kubernetes")
-	return updated, err
+	return m.updateNodeLabels(request)
 }
 
 // ListNodes obtains a list of nodes in a cluster.
@@ -700,22 +914,28 @@ func (m *Manager) ListNodes(clusterID *grpc_infrastructure_go.ClusterId) (*grpc_
 	return m.nodesClient.ListNodes(context.Background(), clusterID)
 }
 
-// RemoveNodes removes a set of nodes from the system.
-func (m *Manager) RemoveNodes(removeNodesRequest *grpc_infrastructure_go.RemoveNodesRequest) (*grpc_common_go.Success, error) {
-	return nil, derrors.NewUnimplementedError("RemoveNodes is not implemented yet")
-}
-
-// Uninstall proceeds to remove all Nalej created elements in the cluster.
-func (m *Manager) Uninstall(request *grpc_installer_go.UninstallClusterRequest, decommissionCallback *monitor.DecommissionCallback) (*grpc_common_go.OpResponse, derrors.Error) {
+// Uninstall proceeds to remove all Nalej created elements in the cluster. If removeFromSM is set, the cluster is
+// removed from system model once the uninstall succeeds.
+func (m *Manager) Uninstall(request *grpc_installer_go.UninstallClusterRequest, decommissionCallback *monitor.DecommissionCallback, removeFromSM bool) (*grpc_common_go.OpResponse, derrors.Error) {
 	log.Debug().Str("requestID", request.RequestId).
 		Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).
 		Str("platform", request.TargetPlatform.String()).Msg("Uninstall request")
+	lErr := m.clusterLocks.Acquire(request.OrganizationId, request.ClusterId, request.RequestId, entities.UninstallOperation)
+	if lErr != nil {
+		return nil, lErr
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			m.clusterLocks.Release(request.OrganizationId, request.ClusterId, request.RequestId)
+		}
+	}()
 	canUninstallErr := m.canUninstallCluster(request.OrganizationId, request.ClusterId)
 	if canUninstallErr != nil {
 		return nil, canUninstallErr
 	}
 	// The cluster can be uninstalled, update its state
-	err := m.updateClusterState(request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_UNINSTALLING)
+	err := m.updateClusterState(entities.UninstallOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_UNINSTALLING)
 	if err != nil {
 		return nil, err
 	}
@@ -725,7 +945,7 @@ func (m *Manager) Uninstall(request *grpc_installer_go.UninstallClusterRequest,
 	response, uErr := m.installerClient.UninstallCluster(ctx, request)
 	if uErr != nil {
 		// Update the state to error
-		err = m.updateClusterState(request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_FAILURE)
+		err = m.updateClusterState(entities.UninstallOperation, request.OrganizationId, request.ClusterId, grpc_infrastructure_go.ClusterState_FAILURE)
 		if err != nil {
 			log.Error().Str("trace", err.DebugReport()).Msg("cannot update failed cluster uninstall")
 		}
@@ -734,11 +954,29 @@ func (m *Manager) Uninstall(request *grpc_installer_go.UninstallClusterRequest,
 	log.Debug().Str("requestID", request.RequestId).
 		Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).
 		Msg("cluster is uninstalling")
-	mon := monitor.NewInstallerMonitor(request.ClusterId, m.installerClient, m.clusterClient, *response)
+	operation := entities.Operation{
+		RequestID:      response.RequestId,
+		OrganizationID: request.OrganizationId,
+		ClusterID:      request.ClusterId,
+		Type:           entities.UninstallOperation,
+		RemoveFromSM:   removeFromSM,
+	}
+	if decommissionCallback != nil {
+		operation.Decommission = decommissionCallback.Request
+	}
+	m.startOperation(operation)
+	launched = true
+	go m.monitorUninstall(request.ClusterId, *response, decommissionCallback)
+	return response, nil
+}
+
+// monitorUninstall follows an uninstall operation until it finishes, triggering the decommission of the
+// cluster afterwards if required.
+func (m *Manager) monitorUninstall(clusterID string, response grpc_common_go.OpResponse, decommissionCallback *monitor.DecommissionCallback) {
+	mon := monitor.NewInstallerMonitor(clusterID, m.installerClient, m.clusterClient, response)
 	mon.RegisterCallback(m.uninstallCallback)
 	mon.RegisterDecommissionCallback(decommissionCallback)
-	go mon.LaunchMonitor()
-	return response, nil
+	m.runMonitor(mon, response.RequestId, entities.UninstallOperation)
 }
 
 // uninstallCallback function called when an uninstall operation has finished on the installer.
@@ -751,7 +989,7 @@ func (m *Manager) uninstallCallback(
 	if err != nil {
 		log.Error().Str("err", err.DebugReport()).Msg("error callback received")
 	}
-	if response == nil {
+	if response == nil && err == nil {
 		return
 	}
 
@@ -759,39 +997,40 @@ func (m *Manager) uninstallCallback(
 	if err != nil || response.Status == grpc_common_go.OpStatus_FAILED {
 		newState = grpc_infrastructure_go.ClusterState_FAILURE
 		log.Warn().Str("requestID", requestID).Str("organizationID", organizationID).
-			Str("clusterID", clusterID).Str("error", response.Error).Msg("uninstall failed")
+			Str("clusterID", clusterID).Str("error", response.GetError()).Msg("uninstall failed")
 	}
-	err = m.updateClusterState(organizationID, clusterID, newState)
+	err = m.updateClusterState(entities.UninstallOperation, organizationID, clusterID, newState)
 	if err != nil {
 		log.Error().Msg("unable to update cluster state after uninstall")
 	}
 	log.Debug().Str("requestID", requestID).
 		Str("organizationID", organizationID).Str("clusterID", clusterID).
 		Msg("cluster has been uninstalled")
+	if op, gErr := m.operations.Get(requestID); gErr == nil && op.RemoveFromSM {
+		if newState == grpc_infrastructure_go.ClusterState_FAILURE {
+			m.removalFailed(requestID, organizationID, clusterID, derrors.NewInternalError("uninstall failed").WithParams(response.GetError()))
+			return
+		}
+		m.removalFinished(requestID, organizationID, clusterID)
+	}
 }
 
 // UninstallAndDecommissionCluster frees the resources of a given cluster.
 func (m *Manager) UninstallAndDecommissionCluster(request *grpc_provisioner_go.DecommissionClusterRequest) (*grpc_common_go.OpResponse, derrors.Error) {
+	// The lock is acquired before contacting the provisioner and it is kept by the uninstall operation.
+	lErr := m.clusterLocks.Acquire(request.GetOrganizationId(), request.GetClusterId(), request.GetRequestId(), entities.UninstallOperation)
+	if lErr != nil {
+		return nil, lErr
+	}
 	// Retrieve the kubeconfig from provisioner
-	getKubeConfigCtx, getKubeConfigCancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer getKubeConfigCancel()
-	kubeConfigResponse, err := m.managementClient.GetKubeConfig(getKubeConfigCtx, &grpc_provisioner_go.ClusterRequest{
-		RequestId:           request.GetRequestId(),
-		OrganizationId:      request.GetOrganizationId(),
-		ClusterId:           request.GetClusterId(),
-		ClusterType:         request.GetClusterType(),
-		IsManagementCluster: request.GetIsManagementCluster(),
-		TargetPlatform:      request.GetTargetPlatform(),
-		AzureCredentials:    request.GetAzureCredentials(),
-		AzureOptions:        request.GetAzureOptions(),
-	})
-	if err != nil {
-		derr := conversions.ToDerror(err)
+	kubeConfig, derr := m.getProvisionedKubeConfig(decommissionKubeConfigRequest(request))
+	if derr != nil {
 		log.Error().
 			Err(derr).
 			Str("DebugReport", derr.DebugReport()).
 			Interface("request", request).
 			Msg("unable to get kubeconfig from cluster")
+		m.clusterLocks.Release(request.GetOrganizationId(), request.GetClusterId(), request.GetRequestId())
 		return nil, derr
 	}
 	// Trigger uninstall
@@ -800,13 +1039,13 @@ func (m *Manager) UninstallAndDecommissionCluster(request *grpc_provisioner_go.D
 		OrganizationId: request.GetOrganizationId(),
 		ClusterId:      request.GetClusterId(),
 		ClusterType:    request.GetClusterType(),
-		KubeConfigRaw:  kubeConfigResponse.GetRawKubeConfig(),
+		KubeConfigRaw:  kubeConfig,
 		TargetPlatform: request.GetTargetPlatform(),
 	}
 	response, derr := m.Uninstall(&uninstallRequest, &monitor.DecommissionCallback{
 		Callback: m.Decommission,
 		Request:  request,
-	})
+	}, false)
 	if derr != nil {
 		log.Error().
 			Err(derr).
@@ -818,6 +1057,31 @@ func (m *Manager) UninstallAndDecommissionCluster(request *grpc_provisioner_go.D
 	return response, nil
 }
 
+// getProvisionedKubeConfig retrieves from the provisioner the kubeconfig of a provisioned cluster.
+func (m *Manager) getProvisionedKubeConfig(request *grpc_provisioner_go.ClusterRequest) (string, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	kubeConfigResponse, err := m.managementClient.GetKubeConfig(ctx, request)
+	if err != nil {
+		return "", conversions.ToDerror(err)
+	}
+	return kubeConfigResponse.GetRawKubeConfig(), nil
+}
+
+// decommissionKubeConfigRequest builds the request to retrieve the kubeconfig of a cluster to be decommissioned.
+func decommissionKubeConfigRequest(request *grpc_provisioner_go.DecommissionClusterRequest) *grpc_provisioner_go.ClusterRequest {
+	return &grpc_provisioner_go.ClusterRequest{
+		RequestId:           request.GetRequestId(),
+		OrganizationId:      request.GetOrganizationId(),
+		ClusterId:           request.GetClusterId(),
+		ClusterType:         request.GetClusterType(),
+		IsManagementCluster: request.GetIsManagementCluster(),
+		TargetPlatform:      request.GetTargetPlatform(),
+		AzureCredentials:    request.GetAzureCredentials(),
+		AzureOptions:        request.GetAzureOptions(),
+	}
+}
+
 func (m *Manager) Decommission(request *grpc_provisioner_go.DecommissionClusterRequest) {
 	decommissionCtx, decommissionCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer decommissionCancel()
@@ -842,15 +1106,48 @@ func (m *Manager) Decommission(request *grpc_provisioner_go.DecommissionClusterR
 			Msg("unable to decommission cluster")
 		return
 	}
-	mon := monitor.NewDecommissionerMonitor(m.decommissionClient, request.GetClusterId(), request.GetRequestId())
-	mon.RegisterCallback(m.decommissionCallback)
-	mon.LaunchMonitor()
+	m.startOperation(entities.Operation{
+		RequestID:      request.GetRequestId(),
+		OrganizationID: request.GetOrganizationId(),
+		ClusterID:      request.GetClusterId(),
+		Type:           entities.DecommissionOperation,
+	})
+	m.monitorDecommission(request.GetOrganizationId(), request.GetClusterId(), request.GetRequestId())
 }
 
-func (m *Manager) decommissionCallback(clusterID string, lastResponse *grpc_common_go.OpResponse, err derrors.Error) {
-	err = m.removeClusterFromSM(lastResponse.GetRequestId(), lastResponse.GetOrganizationId(), clusterID)
+// monitorDecommission follows a decommission operation until it finishes.
+func (m *Manager) monitorDecommission(organizationID string, clusterID string, requestID string) {
+	mon := monitor.NewDecommissionerMonitor(m.decommissionClient, clusterID, requestID)
+	mon.RegisterCallback(func(clusterID string, lastResponse *grpc_common_go.OpResponse, err derrors.Error) {
+		m.decommissionCallback(requestID, organizationID, clusterID, lastResponse, err)
+	})
+	m.runMonitor(mon, requestID, entities.DecommissionOperation)
+}
+
+// decommissionCallback function called when a decommission operation has finished on the provisioner. The cluster
+// is removed from system model unless the decommission failed.
+func (m *Manager) decommissionCallback(requestID string, organizationID string, clusterID string,
+	lastResponse *grpc_common_go.OpResponse, err derrors.Error) {
+	if err != nil || lastResponse.GetStatus() == grpc_common_go.OpStatus_FAILED {
+		if err != nil {
+			log.Error().Str("err", err.DebugReport()).Msg("error callback received")
+		}
+		log.Warn().Str("requestID", requestID).Str("organizationID", organizationID).
+			Str("clusterID", clusterID).Str("error", lastResponse.GetError()).Msg("decommission failed")
+		err = m.updateClusterState(entities.DecommissionOperation, organizationID, clusterID, grpc_infrastructure_go.ClusterState_FAILURE)
+		if err != nil {
+			log.Error().Msg("unable to update cluster state after decommission")
+		}
+		return
+	}
+	err = m.removeClusterFromSM(requestID, organizationID, clusterID)
 	if err != nil {
 		log.Error().Str("err", err.DebugReport()).Msg("could not remove cluster from SM")
+		return
+	}
+	err = m.kubeConfigs.Remove(organizationID, clusterID)
+	if err != nil {
+		log.Warn().Str("clusterID", clusterID).Str("trace", err.DebugReport()).Msg("cannot remove the kubeconfig of the cluster")
 	}
 }
 
@@ -873,13 +1170,27 @@ func (m *Manager) canUninstallCluster(organizationID string, clusterID string) d
 	if hErr != nil {
 		return hErr
 	}
+	issues := uninstallIssues(cluster, hasApps)
+	if len(issues) > 0 {
+		return issues[0]
+	}
+	return nil
+}
+
+// uninstallIssues returns the reasons why a cluster cannot be uninstalled.
+func uninstallIssues(cluster *grpc_infrastructure_go.Cluster, hasApps bool) []derrors.Error {
+	issues := make([]derrors.Error, 0)
 	if hasApps {
-		return derrors.NewFailedPreconditionError("target cluster has deployed applications")
+		issues = append(issues, derrors.NewFailedPreconditionError("target cluster has deployed applications"))
+	}
+	sErr := entities.ValidClusterTransition(entities.UninstallOperation, cluster.State, grpc_infrastructure_go.ClusterState_UNINSTALLING)
+	if sErr != nil {
+		issues = append(issues, sErr)
 	}
 	if cluster.ClusterStatus != grpc_connectivity_manager_go.ClusterStatus_ONLINE_CORDON {
-		return derrors.NewFailedPreconditionError("target cluster must be online and cordoned")
+		issues = append(issues, derrors.NewFailedPreconditionError("target cluster must be online and cordoned"))
 	}
-	return nil
+	return issues
 }
 
 // clusterHasApps checks if any service is deployed on the given cluster.
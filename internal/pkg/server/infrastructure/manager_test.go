@@ -0,0 +1,189 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/clusterclient"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"sync"
+	"time"
+)
+
+// fakeClusters keeps the clusters of system model in memory.
+type fakeClusters struct {
+	grpc_infrastructure_go.ClustersClient
+	sync.Mutex
+	clusters map[string]grpc_infrastructure_go.Cluster
+}
+
+func newFakeClusters(clusters ...grpc_infrastructure_go.Cluster) *fakeClusters {
+	fake := &fakeClusters{clusters: make(map[string]grpc_infrastructure_go.Cluster, 0)}
+	for _, cluster := range clusters {
+		fake.clusters[cluster.ClusterId] = cluster
+	}
+	return fake
+}
+
+func (f *fakeClusters) GetCluster(ctx context.Context, in *grpc_infrastructure_go.ClusterId, opts ...grpc.CallOption) (*grpc_infrastructure_go.Cluster, error) {
+	f.Lock()
+	defer f.Unlock()
+	cluster, exists := f.clusters[in.ClusterId]
+	if !exists {
+		return nil, status.Error(codes.NotFound, "cluster not found")
+	}
+	return &cluster, nil
+}
+
+func (f *fakeClusters) UpdateCluster(ctx context.Context, in *grpc_infrastructure_go.UpdateClusterRequest, opts ...grpc.CallOption) (*grpc_infrastructure_go.Cluster, error) {
+	f.Lock()
+	defer f.Unlock()
+	cluster, exists := f.clusters[in.ClusterId]
+	if !exists {
+		return nil, status.Error(codes.NotFound, "cluster not found")
+	}
+	if in.UpdateClusterState {
+		cluster.State = in.State
+	}
+	f.clusters[in.ClusterId] = cluster
+	return &cluster, nil
+}
+
+// state returns the current state of a cluster.
+func (f *fakeClusters) state(clusterID string) grpc_infrastructure_go.ClusterState {
+	f.Lock()
+	defer f.Unlock()
+	return f.clusters[clusterID].State
+}
+
+// fakeInstaller rejects every install.
+type fakeInstaller struct {
+	grpc_installer_go.InstallerClient
+}
+
+func (f *fakeInstaller) InstallCluster(ctx context.Context, in *grpc_installer_go.InstallRequest, opts ...grpc.CallOption) (*grpc_common_go.OpResponse, error) {
+	return nil, status.Error(codes.Unavailable, "installer not available")
+}
+
+// fakeBus discards the messages sent to the bus.
+type fakeBus struct{}
+
+func (f *fakeBus) SendOps(ctx context.Context, msg proto.Message) derrors.Error {
+	return nil
+}
+
+func (f *fakeBus) SendEvents(ctx context.Context, msg proto.Message) derrors.Error {
+	return nil
+}
+
+var _ = ginkgo.Describe("Manager", func() {
+
+	var tempDir string
+	var clusters *fakeClusters
+	var opJournal *journal.FileJournal
+	var manager Manager
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "managerTest")
+		gomega.Expect(err).To(gomega.Succeed())
+		clusters = newFakeClusters(grpc_infrastructure_go.Cluster{
+			OrganizationId: "org",
+			ClusterId:      "cluster",
+			State:          grpc_infrastructure_go.ClusterState_PROVISIONED,
+		})
+		var jErr derrors.Error
+		opJournal, jErr = journal.NewFileJournal(tempDir)
+		gomega.Expect(jErr).To(gomega.Succeed())
+		manager = NewManager(tempDir, clusters, nil, &fakeInstaller{}, nil, nil, nil, nil, nil, nil,
+			&fakeBus{}, nil, opJournal, nil, OperationConfig{})
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	ginkgo.It("should mark the cluster as failed if the install cannot be launched", func() {
+		_, err := manager.InstallCluster(&grpc_installer_go.InstallRequest{
+			RequestId:      "request",
+			OrganizationId: "org",
+			ClusterId:      "cluster",
+		})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(clusters.state("cluster")).Should(gomega.Equal(grpc_infrastructure_go.ClusterState_FAILURE))
+		_, gErr := manager.operations.Get("request")
+		gomega.Expect(gErr).To(gomega.HaveOccurred())
+		pending, lErr := opJournal.List()
+		gomega.Expect(lErr).To(gomega.Succeed())
+		gomega.Expect(pending).Should(gomega.BeEmpty())
+		gomega.Expect(manager.clusterLocks.Acquire("org", "cluster", "other", entities.InstallOperation)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should uncordon the nodes and restore the cluster if the drain of a scale down is interrupted", func() {
+		client := fake.NewSimpleClientset(
+			&coreV1.Node{ObjectMeta: metaV1.ObjectMeta{Name: "node1"}},
+			&coreV1.Node{ObjectMeta: metaV1.ObjectMeta{Name: "node2"}},
+		)
+		clusters.clusters["cluster"] = grpc_infrastructure_go.Cluster{
+			OrganizationId: "org",
+			ClusterId:      "cluster",
+			State:          grpc_infrastructure_go.ClusterState_SCALING,
+		}
+		gomega.Expect(manager.clusterLocks.Acquire("org", "cluster", "request", entities.ScaleOperation)).To(gomega.Succeed())
+		op := entities.Operation{
+			RequestID:      "request",
+			OrganizationID: "org",
+			ClusterID:      "cluster",
+			Type:           entities.ScaleOperation,
+			Draining:       true,
+		}
+		manager.startOperation(op)
+		// The drain is interrupted by the shutdown once the nodes are cordoned.
+		gomega.Expect(manager.Shutdown(time.Second)).To(gomega.Succeed())
+		manager.drainAndScale(op, &grpc_provisioner_go.ScaleClusterRequest{
+			RequestId:      "request",
+			OrganizationId: "org",
+			ClusterId:      "cluster",
+			NumNodes:       1,
+		}, clusterclient.NewClusterClientFromInterface(client), []string{"node2"}, false)
+		node, err := client.CoreV1().Nodes().Get("node2", metaV1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(node.Spec.Unschedulable).Should(gomega.BeFalse())
+		gomega.Expect(clusters.state("cluster")).Should(gomega.Equal(grpc_infrastructure_go.ClusterState_INSTALLED))
+		pending, lErr := opJournal.List()
+		gomega.Expect(lErr).To(gomega.Succeed())
+		gomega.Expect(pending).Should(gomega.BeEmpty())
+		gomega.Expect(manager.clusterLocks.Acquire("org", "cluster", "other", entities.ScaleOperation)).To(gomega.Succeed())
+	})
+})
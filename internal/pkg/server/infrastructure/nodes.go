@@ -0,0 +1,378 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/clusterclient"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"time"
+)
+
+// DeleteKubernetesNodeHeader is the gRPC metadata key used by clients to request that the Kubernetes Node objects
+// are deleted when the nodes are removed. Set it to "true" to delete them.
+const DeleteKubernetesNodeHeader = "delete-kubernetes-node"
+
+// GetDeleteKubernetesNode checks if the client requested the deletion of the Kubernetes Node objects.
+func GetDeleteKubernetesNode(ctx context.Context) bool {
+	return metadataFlag(ctx, DeleteKubernetesNodeHeader)
+}
+
+// metadataFlag checks if a gRPC metadata key sent by the client is set to "true".
+func metadataFlag(ctx context.Context, key string) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	values := md.Get(key)
+	return len(values) > 0 && strings.EqualFold(values[0], "true")
+}
+
+// RemoveNodes removes a set of nodes of an imported cluster. The request returns once the nodes have been validated,
+// and the removal continues as an operation: each node is cordoned and drained on Kubernetes, its Node object is
+// deleted if requested, and it is removed from system model. All the nodes are processed even if some of them fail,
+// and the result of each node is recorded in the operation.
+func (m *Manager) RemoveNodes(request *grpc_infrastructure_go.RemoveNodesRequest, deleteKubernetesNode bool) (*grpc_common_go.Success, derrors.Error) {
+	log.Debug().Str("requestID", request.RequestId).Str("organizationID", request.OrganizationId).
+		Strs("nodes", request.Nodes).Bool("deleteKubernetesNode", deleteKubernetesNode).Msg("RemoveNodes")
+	nodes, err := m.findNodes(request.OrganizationId, request.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	// Each operation locks a single cluster.
+	clusterID := nodes[0].ClusterId
+	for _, node := range nodes {
+		if node.ClusterId != clusterID {
+			return nil, derrors.NewInvalidArgumentError("all the nodes of a request must belong to the same cluster").
+				WithParams(clusterID, node.ClusterId)
+		}
+	}
+	lErr := m.clusterLocks.Acquire(request.OrganizationId, clusterID, request.RequestId, entities.RemoveNodesOperation)
+	if lErr != nil {
+		return nil, lErr
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			m.clusterLocks.Release(request.OrganizationId, clusterID, request.RequestId)
+		}
+	}()
+	cluster, err := m.getCluster(request.OrganizationId, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := m.isImported(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if !imported {
+		return nil, derrors.NewFailedPreconditionError("nodes can only be removed from imported clusters").WithParams(clusterID)
+	}
+	_, err = m.kubeConfigs.Get(request.OrganizationId, clusterID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewFailedPreconditionError("the kubeconfig of the cluster is not available").WithParams(clusterID)
+		}
+		return nil, err
+	}
+	m.startOperation(entities.Operation{
+		RequestID:      request.RequestId,
+		OrganizationID: request.OrganizationId,
+		ClusterID:      clusterID,
+		Type:           entities.RemoveNodesOperation,
+		RemoveNodes: &entities.NodeRemoval{
+			Nodes:                request.Nodes,
+			DeleteKubernetesNode: deleteKubernetesNode,
+		},
+	})
+	op, err := m.operations.Get(request.RequestId)
+	if err != nil {
+		return nil, err
+	}
+	launched = true
+	go m.removeNodeSet(*op)
+	return &grpc_common_go.Success{}, nil
+}
+
+// findNodes resolves the identifiers of a set of nodes of an organization to the nodes in system model.
+func (m *Manager) findNodes(organizationID string, nodeIDs []string) ([]*grpc_infrastructure_go.Node, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	clusters, err := m.clusterClient.ListClusters(ctx, &grpc_organization_go.OrganizationId{OrganizationId: organizationID})
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	wanted := make(map[string]bool, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		wanted[nodeID] = true
+	}
+	result := make([]*grpc_infrastructure_go.Node, 0, len(nodeIDs))
+	for _, cluster := range clusters.Clusters {
+		nodeList, lErr := m.ListNodes(&grpc_infrastructure_go.ClusterId{
+			OrganizationId: organizationID,
+			ClusterId:      cluster.ClusterId,
+		})
+		if lErr != nil {
+			return nil, conversions.ToDerror(lErr)
+		}
+		for _, node := range nodeList.Nodes {
+			if wanted[node.NodeId] {
+				result = append(result, node)
+				delete(wanted, node.NodeId)
+			}
+		}
+	}
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for nodeID := range wanted {
+			missing = append(missing, nodeID)
+		}
+		return nil, derrors.NewNotFoundError("nodes not found").WithParams(missing)
+	}
+	return result, nil
+}
+
+// removeNodeSet removes the nodes of an operation that have not been processed yet, recording the result of each
+// one in the journal. If the manager shuts down, the operation is left in the journal and the removal continues with
+// the pending nodes once it is resumed.
+func (m *Manager) removeNodeSet(op entities.Operation) {
+	if !m.shutdown.begin() {
+		log.Info().Str("requestID", op.RequestID).Msg("shutting down, operation left in the journal")
+		return
+	}
+	defer m.shutdown.end()
+	processed := make(map[string]bool, len(op.RemoveNodes.Results))
+	for _, result := range op.RemoveNodes.Results {
+		processed[result.NodeID] = true
+	}
+	var client *clusterclient.ClusterClient
+	kubeConfig, cErr := m.kubeConfigs.Get(op.OrganizationID, op.ClusterID)
+	if cErr == nil {
+		client, cErr = clusterclient.NewClusterClient(kubeConfig)
+	}
+	drainTimeout := m.operationConfig.Deadlines[entities.RemoveNodesOperation]
+	if drainTimeout <= 0 {
+		// Draining always needs a limit as the nodes are drained one after another.
+		drainTimeout = DefaultRemoveNodesDeadline
+	}
+	for _, nodeID := range op.RemoveNodes.Nodes {
+		if processed[nodeID] {
+			continue
+		}
+		if m.shutdown.isStopping() {
+			log.Info().Str("requestID", op.RequestID).Msg("node removal suspended")
+			return
+		}
+		err := cErr
+		if err == nil {
+			err = m.removeNode(op.RequestID, op.OrganizationID, client, nodeID, drainTimeout, op.RemoveNodes.DeleteKubernetesNode)
+		}
+		result := entities.NodeResult{NodeID: nodeID}
+		if err != nil {
+			log.Warn().Str("nodeID", nodeID).Str("trace", err.DebugReport()).Msg("node could not be removed")
+			result.Error = err.Error()
+		} else {
+			log.Info().Str("nodeID", nodeID).Msg("node removed")
+		}
+		updated := m.operations.AddNodeResult(op.RequestID, result)
+		if updated != nil {
+			op = *updated
+			jErr := m.journal.Put(op)
+			if jErr != nil {
+				log.Error().Str("requestID", op.RequestID).Str("trace", jErr.DebugReport()).Msg("cannot store node result in the journal")
+			}
+		}
+		m.reportRemoval(op.RequestID, op.OrganizationID, fmt.Sprintf("PROCESSED %d/%d", len(op.RemoveNodes.Results), len(op.RemoveNodes.Nodes)))
+	}
+	m.nodeRemovalFinished(op)
+	m.finishOperation(op.RequestID, entities.RemoveNodesOperation)
+}
+
+// nodeRemovalFinished publishes the result of a remove nodes operation. The error lists the nodes that could not be
+// removed.
+func (m *Manager) nodeRemovalFinished(op entities.Operation) {
+	failed := make([]string, 0)
+	for _, result := range op.RemoveNodes.Results {
+		if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.NodeID, result.Error))
+		}
+	}
+	event := &grpc_common_go.OpResponse{
+		RequestId:      op.RequestID,
+		OrganizationId: op.OrganizationID,
+		Status:         grpc_common_go.OpStatus_SUCCESS,
+		Info:           fmt.Sprintf("%d nodes removed", len(op.RemoveNodes.Results)),
+	}
+	if len(failed) > 0 {
+		event.Status = grpc_common_go.OpStatus_FAILED
+		event.Info = ""
+		event.Error = fmt.Sprintf("%d of %d nodes could not be removed: %s", len(failed), len(op.RemoveNodes.Results),
+			strings.Join(failed, ", "))
+	}
+	m.sendOperationEvent(event)
+}
+
+// removeNode cordons and drains a node, deletes it from Kubernetes if required, and removes it from system model.
+func (m *Manager) removeNode(requestID string, organizationID string, client *clusterclient.ClusterClient,
+	nodeID string, drainTimeout time.Duration, deleteKubernetesNode bool) derrors.Error {
+	getCtx, getCancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer getCancel()
+	node, gErr := m.nodesClient.GetNode(getCtx, &grpc_infrastructure_go.NodeId{
+		OrganizationId: organizationID,
+		NodeId:         nodeID,
+	})
+	if gErr != nil {
+		return conversions.ToDerror(gErr)
+	}
+	name, err := client.FindNodeByIP(node.Ip)
+	if err != nil {
+		return err
+	}
+	err = client.CordonNode(name)
+	if err != nil {
+		return err
+	}
+	err = client.DrainNode(name, drainTimeout)
+	if err != nil {
+		return err
+	}
+	if deleteKubernetesNode {
+		err = client.DeleteNode(name)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	_, rErr := m.nodesClient.RemoveNodes(ctx, &grpc_infrastructure_go.RemoveNodesRequest{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		Nodes:          []string{node.NodeId},
+	})
+	if rErr != nil {
+		return conversions.ToDerror(rErr)
+	}
+	return nil
+}
+
+// updateNodeLabels updates the labels of a node in system model and in its Kubernetes node. If the Kubernetes node
+// cannot be updated, the labels are restored in system model so that both remain consistent.
+func (m *Manager) updateNodeLabels(request *grpc_infrastructure_go.UpdateNodeRequest) (*grpc_infrastructure_go.Node, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	previous, err := m.nodesClient.GetNode(ctx, &grpc_infrastructure_go.NodeId{
+		OrganizationId: request.OrganizationId,
+		NodeId:         request.NodeId,
+	})
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	updated, err := m.nodesClient.UpdateNode(ctx, request)
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	pErr := m.propagateNodeLabels(updated, request)
+	if pErr == nil {
+		return updated, nil
+	}
+	log.Warn().Str("organizationID", request.OrganizationId).Str("nodeID", request.NodeId).
+		Str("trace", pErr.DebugReport()).Msg("cannot update node labels in kubernetes, restoring system model")
+	rErr := m.restoreNodeLabels(previous, request)
+	if rErr != nil {
+		log.Error().Str("organizationID", request.OrganizationId).Str("nodeID", request.NodeId).
+			Str("trace", rErr.DebugReport()).Msg("node labels differ between system model and kubernetes")
+		return nil, derrors.NewInternalError("node labels updated in system model but not in kubernetes", pErr).
+			WithParams(request.NodeId)
+	}
+	return nil, pErr
+}
+
+// propagateNodeLabels applies the label changes of a request to the Kubernetes node. It fails if the kubeconfig of
+// the cluster is not available, so that the labels are not changed only in system model.
+func (m *Manager) propagateNodeLabels(node *grpc_infrastructure_go.Node, request *grpc_infrastructure_go.UpdateNodeRequest) derrors.Error {
+	kubeConfig, err := m.clusterKubeConfig(node.OrganizationId, node.ClusterId, nil)
+	if err != nil {
+		return err
+	}
+	client, err := clusterclient.NewClusterClient(kubeConfig)
+	if err != nil {
+		return err
+	}
+	name, err := client.FindNodeByIP(node.Ip)
+	if err != nil {
+		return err
+	}
+	var add map[string]string
+	var remove []string
+	if request.AddLabels {
+		add = request.Labels
+	}
+	if request.RemoveLabels {
+		remove = make([]string, 0, len(request.Labels))
+		for key := range request.Labels {
+			remove = append(remove, key)
+		}
+	}
+	return client.UpdateNodeLabels(name, add, remove)
+}
+
+// restoreNodeLabels sets the labels modified by a request back to the values they had on the previous node.
+func (m *Manager) restoreNodeLabels(previous *grpc_infrastructure_go.Node, request *grpc_infrastructure_go.UpdateNodeRequest) derrors.Error {
+	restore := make(map[string]string, 0)
+	drop := make(map[string]string, 0)
+	for key, value := range request.Labels {
+		if oldValue, existed := previous.Labels[key]; existed {
+			restore[key] = oldValue
+		} else {
+			drop[key] = value
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	if len(drop) > 0 {
+		_, err := m.nodesClient.UpdateNode(ctx, &grpc_infrastructure_go.UpdateNodeRequest{
+			OrganizationId: request.OrganizationId,
+			NodeId:         request.NodeId,
+			RemoveLabels:   true,
+			Labels:         drop,
+		})
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+	}
+	if len(restore) > 0 {
+		_, err := m.nodesClient.UpdateNode(ctx, &grpc_infrastructure_go.UpdateNodeRequest{
+			OrganizationId: request.OrganizationId,
+			NodeId:         request.NodeId,
+			AddLabels:      true,
+			Labels:         restore,
+		})
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-manager-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/monitor"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// Default maximum duration of each type of operation.
+const (
+	DefaultProvisionDeadline    = time.Hour
+	DefaultInstallDeadline      = time.Minute * 45
+	DefaultScaleDeadline        = time.Hour
+	DefaultUninstallDeadline    = time.Minute * 30
+	DefaultDecommissionDeadline = time.Hour
+	// DefaultRemoveDeadline only covers the cordon and drain of a cluster being removed, the uninstall that follows
+	// has its own deadline.
+	DefaultRemoveDeadline = time.Minute * 30
+	// DefaultRemoveNodesDeadline is the time to drain each node removed by RemoveNodes.
+	DefaultRemoveNodesDeadline = time.Minute * 5
+)
+
+// Default polling policies. Provisions and decommissions on cloud providers take tens of minutes so they are
+// checked less often than installs.
+var (
+	DefaultProvisionPollPolicy    = monitor.PollPolicy{InitialInterval: time.Second * 30, MaxInterval: time.Minute * 2, Multiplier: 1.5, Jitter: 0.2, MaxFailures: monitor.MaxConnFailures}
+	DefaultInstallPollPolicy      = monitor.PollPolicy{InitialInterval: time.Second * 5, MaxInterval: time.Second * 30, Multiplier: 1.5, Jitter: 0.2, MaxFailures: monitor.MaxConnFailures}
+	DefaultScalePollPolicy        = DefaultProvisionPollPolicy
+	DefaultUninstallPollPolicy    = DefaultInstallPollPolicy
+	DefaultDecommissionPollPolicy = DefaultProvisionPollPolicy
+)
+
+// OperationConfig contains the settings used to follow the operations.
+type OperationConfig struct {
+	// Deadlines contains the maximum duration of each type of operation measured from its creation. Operations
+	// without a deadline are followed until they finish.
+	Deadlines map[entities.OperationType]time.Duration
+	// PollPolicies contains the polling policy of each type of operation. Operations without a policy use the
+	// default one of the monitor package.
+	PollPolicies map[entities.OperationType]monitor.PollPolicy
+}
+
+// startOperation registers a new operation and records it in the journal so that its monitor can be resumed
+// after a restart.
+func (m *Manager) startOperation(operation entities.Operation) {
+	operation.Created = time.Now().Unix()
+	m.updateOperation(operation)
+}
+
+// updateOperation replaces a registered operation and records the change in the journal.
+func (m *Manager) updateOperation(operation entities.Operation) {
+	m.operations.Add(operation)
+	err := m.journal.Put(operation)
+	if err != nil {
+		log.Error().Str("requestID", operation.RequestID).Str("type", string(operation.Type)).
+			Str("trace", err.DebugReport()).Msg("cannot store operation in the journal")
+	}
+}
+
+// finishOperation removes an operation from the registry and the journal once its callback has been processed,
+// releasing the lock of the cluster. Notice that chained operations such as provision and install, or uninstall and
+// decommission, share the same request identifier so the entry is only removed if it has not been replaced by the
+// next step.
+func (m *Manager) finishOperation(requestID string, operationType entities.OperationType) {
+	current, err := m.operations.Get(requestID)
+	if err != nil {
+		log.Debug().Str("requestID", requestID).Msg("operation not found in the registry")
+		return
+	}
+	if current.Type != operationType {
+		log.Debug().Str("requestID", requestID).Str("type", string(operationType)).
+			Str("next", string(current.Type)).Msg("operation continues with a follow-up step")
+		return
+	}
+	m.operations.Remove(requestID)
+	m.clusterLocks.Release(current.OrganizationID, current.ClusterID, requestID)
+	err = m.journal.Remove(requestID)
+	if err != nil {
+		log.Error().Str("requestID", requestID).Str("trace", err.DebugReport()).Msg("cannot remove operation from the journal")
+	}
+}
+
+// runMonitor blocks until the monitor finishes and its callbacks have been processed, and then marks the
+// operation as finished. If progress events are consumed from the bus, the monitor is subscribed to them. The
+// deadline of the operation is computed from its creation so that resumed operations keep their original deadline.
+func (m *Manager) runMonitor(mon monitor.Monitor, requestID string, operationType entities.OperationType) {
+	if !m.shutdown.begin() {
+		log.Info().Str("requestID", requestID).Str("type", string(operationType)).Msg("shutting down, operation left in the journal")
+		return
+	}
+	defer m.shutdown.end()
+	if m.progressConsumer != nil {
+		events := m.progressConsumer.Subscribe(requestID)
+		defer m.progressConsumer.Unsubscribe(requestID, events)
+		mon.RegisterProgressEvents(events)
+	}
+	op, err := m.operations.Get(requestID)
+	if err != nil {
+		log.Warn().Str("requestID", requestID).Msg("monitor launched for an unregistered operation")
+	} else if maxDuration := m.operationConfig.Deadlines[operationType]; maxDuration > 0 {
+		mon.SetDeadline(time.Unix(op.Created, 0).Add(maxDuration))
+	}
+	if policy, exists := m.operationConfig.PollPolicies[operationType]; exists {
+		mon.SetPollPolicy(policy)
+	}
+	m.operations.AttachMonitor(requestID, mon)
+	mon.LaunchMonitor()
+	if mon.Suspended() {
+		// The operation is kept in the journal so that it is resumed on the next start.
+		log.Info().Str("requestID", requestID).Str("type", string(operationType)).Msg("operation suspended")
+		return
+	}
+	if mon.Expired() && op != nil {
+		m.operationExpired(op)
+	}
+	m.finishOperation(requestID, operationType)
+}
+
+// operationExpired publishes the failure of an operation that did not finish before its deadline. The cluster
+// state is updated by the callback of the monitor.
+func (m *Manager) operationExpired(op *entities.Operation) {
+	log.Warn().Str("requestID", op.RequestID).Str("organizationID", op.OrganizationID).
+		Str("clusterID", op.ClusterID).Str("type", string(op.Type)).Msg("operation did not finish before its deadline")
+	m.sendOperationEvent(&grpc_common_go.OpResponse{
+		RequestId:      op.RequestID,
+		OrganizationId: op.OrganizationID,
+		Status:         grpc_common_go.OpStatus_FAILED,
+		Error:          fmt.Sprintf("%s operation did not finish before its deadline", op.Type),
+	})
+}
+
+// ResumeOperations launches the monitors of the operations that were in progress when the infrastructure manager
+// was stopped. This method is expected to be called once on startup, or once the replica becomes the leader when
+// the leader election is enabled so that it adopts the operations followed by the previous leader.
+func (m *Manager) ResumeOperations() derrors.Error {
+	operations, err := m.journal.List()
+	if err != nil {
+		return err
+	}
+	for _, op := range operations {
+		log.Info().Str("requestID", op.RequestID).Str("organizationID", op.OrganizationID).
+			Str("clusterID", op.ClusterID).Str("type", string(op.Type)).Msg("resuming operation")
+		m.resumeOperation(op)
+	}
+	return nil
+}
+
+// resumeOperation launches the monitor associated with an operation stored in the journal, acquiring again the lock
+// of the cluster.
+func (m *Manager) resumeOperation(op entities.Operation) {
+	m.operations.Add(op)
+	lErr := m.clusterLocks.Acquire(op.OrganizationID, op.ClusterID, op.RequestID, op.Type)
+	if lErr != nil {
+		log.Warn().Str("requestID", op.RequestID).Str("trace", lErr.DebugReport()).Msg("cluster has more than one operation in the journal")
+	}
+	switch op.Type {
+	case entities.ProvisionOperation:
+		go m.monitorProvision(grpc_infrastructure_manager_go.ProvisionerResponse{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+			ClusterId:      op.ClusterID,
+		})
+	case entities.InstallOperation:
+		go m.monitorInstall(op.ClusterID, grpc_common_go.OpResponse{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+		})
+	case entities.ScaleOperation:
+		if op.Draining {
+			go m.resumeScaleDown(op)
+			return
+		}
+		go m.monitorScale(grpc_infrastructure_manager_go.ProvisionerResponse{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+			ClusterId:      op.ClusterID,
+		})
+	case entities.UninstallOperation:
+		var decommissionCallback *monitor.DecommissionCallback
+		if op.Decommission != nil {
+			decommissionCallback = &monitor.DecommissionCallback{
+				Callback: m.Decommission,
+				Request:  op.Decommission,
+			}
+		}
+		go m.monitorUninstall(op.ClusterID, grpc_common_go.OpResponse{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+		}, decommissionCallback)
+	case entities.DecommissionOperation:
+		go m.monitorDecommission(op.OrganizationID, op.ClusterID, op.RequestID)
+	case entities.RemoveOperation:
+		go m.removeCluster(op)
+	case entities.RemoveNodesOperation:
+		go m.removeNodeSet(op)
+	default:
+		log.Warn().Str("requestID", op.RequestID).Str("type", string(op.Type)).Msg("unknown operation type, removing it from the journal")
+		m.operations.Remove(op.RequestID)
+		m.clusterLocks.Release(op.OrganizationID, op.ClusterID, op.RequestID)
+		rErr := m.journal.Remove(op.RequestID)
+		if rErr != nil {
+			log.Error().Str("trace", rErr.DebugReport()).Msg("cannot remove unknown operation from the journal")
+		}
+	}
+}
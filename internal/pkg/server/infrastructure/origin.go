@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+)
+
+// ClusterOriginLabel records in system model how a cluster joined the platform, so that the operations that only
+// apply to imported or to provisioned clusters can tell them apart.
+const ClusterOriginLabel = "nalej.io/cluster-origin"
+
+const (
+	// ImportedOrigin is the origin of the existing clusters installed by the platform.
+	ImportedOrigin = "imported"
+	// ProvisionedOrigin is the origin of the clusters provisioned by the platform.
+	ProvisionedOrigin = "provisioned"
+)
+
+// labelOrigin records the origin of a newly registered cluster.
+func (m *Manager) labelOrigin(organizationID string, clusterID string, origin string) derrors.Error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	_, err := m.clusterClient.UpdateCluster(ctx, &grpc_infrastructure_go.UpdateClusterRequest{
+		OrganizationId: organizationID,
+		ClusterId:      clusterID,
+		AddLabels:      true,
+		Labels:         map[string]string{ClusterOriginLabel: origin},
+	})
+	if err != nil {
+		return conversions.ToDerror(err)
+	}
+	return nil
+}
+
+// isImported checks whether a cluster was imported. Clusters registered before their origin was recorded are
+// considered imported if their kubeconfig was stored, otherwise the operator must label them.
+func (m *Manager) isImported(cluster *grpc_infrastructure_go.Cluster) (bool, derrors.Error) {
+	switch cluster.Labels[ClusterOriginLabel] {
+	case ImportedOrigin:
+		return true, nil
+	case ProvisionedOrigin:
+		return false, nil
+	}
+	_, err := m.kubeConfigs.Get(cluster.OrganizationId, cluster.ClusterId)
+	if err == nil {
+		return true, nil
+	}
+	if err.Type() != derrors.NotFound {
+		return false, err
+	}
+	return false, derrors.NewFailedPreconditionError("the origin of the cluster is unknown, label it as imported or provisioned").
+		WithParams(cluster.ClusterId, ClusterOriginLabel)
+}
+
+// clusterKubeConfig retrieves the kubeconfig of a cluster. Imported clusters keep the one received on install, and
+// provisioned clusters the one returned once their provision finishes. Provisioned clusters without a stored
+// kubeconfig are looked up on the provisioner if the caller has the credentials of their platform, and the result
+// is stored for later use. Otherwise a FailedPrecondition error is returned.
+func (m *Manager) clusterKubeConfig(organizationID string, clusterID string, provisioned *grpc_provisioner_go.ClusterRequest) (string, derrors.Error) {
+	kubeConfig, fetched, err := m.lookupKubeConfig(organizationID, clusterID, provisioned)
+	if err != nil {
+		return "", err
+	}
+	if fetched {
+		m.storeProvisionedKubeConfig(organizationID, clusterID, kubeConfig)
+	}
+	return kubeConfig, nil
+}
+
+// lookupKubeConfig retrieves the kubeconfig of a cluster like clusterKubeConfig without storing it. It also returns
+// whether the kubeconfig was retrieved from the provisioner.
+func (m *Manager) lookupKubeConfig(organizationID string, clusterID string, provisioned *grpc_provisioner_go.ClusterRequest) (string, bool, derrors.Error) {
+	kubeConfig, err := m.kubeConfigs.Get(organizationID, clusterID)
+	if err == nil {
+		return kubeConfig, false, nil
+	}
+	if err.Type() != derrors.NotFound {
+		return "", false, err
+	}
+	if provisioned == nil {
+		return "", false, derrors.NewFailedPreconditionError("the kubeconfig of the cluster is not available").WithParams(clusterID)
+	}
+	kubeConfig, err = m.getProvisionedKubeConfig(provisioned)
+	if err != nil {
+		return "", false, err
+	}
+	return kubeConfig, true, nil
+}
+
+// storeProvisionedKubeConfig keeps the kubeconfig retrieved from the provisioner for later use.
+func (m *Manager) storeProvisionedKubeConfig(organizationID string, clusterID string, kubeConfig string) {
+	err := m.kubeConfigs.Put(organizationID, clusterID, kubeConfig)
+	if err != nil {
+		log.Warn().Str("clusterID", clusterID).Str("trace", err.DebugReport()).Msg("cannot store the kubeconfig of the provisioned cluster")
+	}
+}
+
+// scaleKubeConfigRequest builds the request to retrieve the kubeconfig of a cluster to be scaled.
+func scaleKubeConfigRequest(request *grpc_provisioner_go.ScaleClusterRequest) *grpc_provisioner_go.ClusterRequest {
+	return &grpc_provisioner_go.ClusterRequest{
+		RequestId:           request.GetRequestId(),
+		OrganizationId:      request.GetOrganizationId(),
+		ClusterId:           request.GetClusterId(),
+		ClusterType:         request.GetClusterType(),
+		IsManagementCluster: request.GetIsManagementCluster(),
+		TargetPlatform:      request.GetTargetPlatform(),
+		AzureCredentials:    request.GetAzureCredentials(),
+		AzureOptions:        request.GetAzureOptions(),
+	}
+}
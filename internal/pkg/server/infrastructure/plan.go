@@ -0,0 +1,199 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/clusterclient"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sort"
+)
+
+// DryRunHeader is the gRPC metadata key used by clients to obtain the plan of an operation without performing it.
+// Set it to "true" to request a dry run.
+const DryRunHeader = "dry-run"
+
+// OperationPlanHeader is the gRPC response metadata key that contains the plan of a dry run encoded as JSON.
+const OperationPlanHeader = "operation-plan"
+
+// GetDryRun checks if the client requested a dry run.
+func GetDryRun(ctx context.Context) bool {
+	return metadataFlag(ctx, DryRunHeader)
+}
+
+// sendPlan returns the plan of a dry run to the client as response metadata, and also returns it encoded as JSON so
+// that it can be set in the response. If the plan is blocked, the issues are returned as a FailedPrecondition error
+// so that the dry run cannot be mistaken for a launched operation.
+func sendPlan(ctx context.Context, plan *entities.OperationPlan) (string, error) {
+	content, err := json.Marshal(plan)
+	if err != nil {
+		return "", conversions.ToGRPCError(derrors.AsError(err, "cannot marshal operation plan"))
+	}
+	err = grpc.SetHeader(ctx, metadata.Pairs(OperationPlanHeader, string(content)))
+	if err != nil {
+		return "", conversions.ToGRPCError(derrors.AsError(err, "cannot send operation plan"))
+	}
+	return string(content), planError(plan, string(content))
+}
+
+// planError returns the FailedPrecondition error of a blocked plan, or nil if the operation can be performed. Each
+// issue is returned as a precondition violation of the cluster, and the plan encoded as JSON is attached as debug
+// information as clients may not receive the response metadata of a failed request.
+func planError(plan *entities.OperationPlan, content string) error {
+	if !plan.Blocked() {
+		return nil
+	}
+	violations := make([]*errdetails.PreconditionFailure_Violation, 0, len(plan.Issues))
+	for _, issue := range plan.Issues {
+		violations = append(violations, &errdetails.PreconditionFailure_Violation{
+			Type:        string(plan.Type),
+			Subject:     plan.ClusterID,
+			Description: issue,
+		})
+	}
+	st := status.New(codes.FailedPrecondition, "dry run: "+plan.Summary())
+	detailed, err := st.WithDetails(
+		&errdetails.PreconditionFailure{Violations: violations},
+		&errdetails.DebugInfo{Detail: content})
+	if err != nil {
+		log.Warn().Str("clusterID", plan.ClusterID).Err(err).Msg("cannot attach the plan to the dry run error")
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// PlanUninstall checks if a cluster can be uninstalled and describes the steps of the uninstall.
+func (m *Manager) PlanUninstall(request *grpc_installer_go.UninstallClusterRequest) *entities.OperationPlan {
+	plan := entities.NewOperationPlan(entities.UninstallOperation, request.RequestId, request.OrganizationId, request.ClusterId)
+	_, err := clusterclient.NewClusterClient(request.KubeConfigRaw)
+	plan.AddIssue(err)
+	m.planUninstall(plan)
+	return plan
+}
+
+// PlanDecommission checks if a cluster can be uninstalled and decommissioned, and describes the steps of both
+// operations.
+func (m *Manager) PlanDecommission(request *grpc_provisioner_go.DecommissionClusterRequest) *entities.OperationPlan {
+	plan := entities.NewOperationPlan(entities.DecommissionOperation, request.RequestId, request.OrganizationId, request.ClusterId)
+	plan.AddStep("retrieve the kubeconfig of the cluster from the provisioner")
+	_, err := m.getProvisionedKubeConfig(decommissionKubeConfigRequest(request))
+	plan.AddIssue(err)
+	m.planUninstall(plan)
+	plan.AddStep("decommission the cluster infrastructure with the provisioner")
+	plan.AddStep("remove the cluster and its nodes from system model")
+	return plan
+}
+
+// PlanScale checks if a cluster can be scaled and describes the steps of the scale, including the nodes that a
+// scale down would drain.
+func (m *Manager) PlanScale(request *grpc_provisioner_go.ScaleClusterRequest, force bool) *entities.OperationPlan {
+	plan := entities.NewOperationPlan(entities.ScaleOperation, request.RequestId, request.OrganizationId, request.ClusterId)
+	cluster := m.planCluster(plan)
+	if cluster != nil {
+		plan.AddIssue(entities.ValidClusterTransition(entities.ScaleOperation, cluster.State, grpc_infrastructure_go.ClusterState_SCALING))
+		releaseQuota, err := m.checkScaleQuota(request)
+		if err == nil {
+			releaseQuota()
+		}
+		plan.AddIssue(err)
+	}
+	scaleDown, err := m.inspectScaleDown(request, false)
+	plan.AddIssue(err)
+	if scaleDown != nil {
+		if !force {
+			plan.AddIssue(scaleDown.blockingIssue(request.ClusterId))
+		}
+		for _, node := range scaleDown.removed {
+			plan.Nodes = append(plan.Nodes, node.Name)
+		}
+		plan.Services = append(plan.Services, scaleDown.services...)
+		if len(scaleDown.removed) > 0 {
+			plan.AddStep(fmt.Sprintf("cordon and drain %d nodes", len(scaleDown.removed)))
+		}
+	}
+	plan.AddStep("set the cluster state to SCALING")
+	plan.AddStep(fmt.Sprintf("scale the cluster to %d nodes with the provisioner", request.NumNodes))
+	plan.AddStep("set the cluster state to INSTALLED")
+	return plan
+}
+
+// planUninstall adds to a plan the checks and the steps of an uninstall.
+func (m *Manager) planUninstall(plan *entities.OperationPlan) {
+	cluster := m.planCluster(plan)
+	if cluster != nil {
+		services, err := m.clusterServices(plan.OrganizationID, plan.ClusterID)
+		plan.AddIssue(err)
+		for serviceID, name := range services {
+			plan.Services = append(plan.Services, fmt.Sprintf("%s (%s)", name, serviceID))
+		}
+		sort.Strings(plan.Services)
+		for _, issue := range uninstallIssues(cluster, len(services) > 0) {
+			plan.AddIssue(issue)
+		}
+	}
+	m.planNodes(plan)
+	plan.AddStep("set the cluster state to UNINSTALLING")
+	plan.AddStep("uninstall the platform components with the installer")
+	plan.AddStep("set the cluster state to PROVISIONED")
+}
+
+// planCluster adds to a plan the state of the cluster and any operation that would prevent launching a new one. The
+// cluster is returned if it can be retrieved.
+func (m *Manager) planCluster(plan *entities.OperationPlan) *grpc_infrastructure_go.Cluster {
+	requestID, operationType, locked := m.clusterLocks.Holder(plan.OrganizationID, plan.ClusterID)
+	if locked && requestID != plan.RequestID {
+		plan.AddIssue(derrors.NewFailedPreconditionError(
+			fmt.Sprintf("cluster has an ongoing %s operation with request_id %s", operationType, requestID)))
+	}
+	cluster, err := m.getCluster(plan.OrganizationID, plan.ClusterID)
+	if err != nil {
+		plan.AddIssue(err)
+		return nil
+	}
+	plan.ClusterState = cluster.State.String()
+	return cluster
+}
+
+// planNodes adds to a plan all the nodes of the cluster registered in system model.
+func (m *Manager) planNodes(plan *entities.OperationPlan) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	nodes, err := m.nodesClient.ListNodes(ctx, &grpc_infrastructure_go.ClusterId{
+		OrganizationId: plan.OrganizationID,
+		ClusterId:      plan.ClusterID,
+	})
+	if err != nil {
+		plan.AddIssue(conversions.ToDerror(err))
+		return
+	}
+	for _, node := range nodes.Nodes {
+		plan.Nodes = append(plan.Nodes, fmt.Sprintf("%s (%s)", node.NodeId, node.Ip))
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = ginkgo.Describe("Dry run plan", func() {
+
+	ginkgo.It("should return the issues and the plan of a blocked operation", func() {
+		plan := entities.NewOperationPlan(entities.ScaleOperation, "", "org", "cluster")
+		plan.AddStep("set the cluster state to SCALING")
+		plan.AddIssue(derrors.NewFailedPreconditionError("cluster is not installed"))
+		content, err := json.Marshal(plan)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		st, ok := status.FromError(planError(plan, string(content)))
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(st.Code()).Should(gomega.Equal(codes.FailedPrecondition))
+		details := st.Details()
+		gomega.Expect(len(details)).Should(gomega.Equal(2))
+		failure, ok := details[0].(*errdetails.PreconditionFailure)
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(len(failure.Violations)).Should(gomega.Equal(1))
+		gomega.Expect(failure.Violations[0].Subject).Should(gomega.Equal("cluster"))
+		gomega.Expect(failure.Violations[0].Description).Should(gomega.Equal(plan.Issues[0]))
+		debug, ok := details[1].(*errdetails.DebugInfo)
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(debug.Detail).Should(gomega.Equal(string(content)))
+	})
+
+	ginkgo.It("should not fail an operation without issues", func() {
+		plan := entities.NewOperationPlan(entities.UninstallOperation, "", "org", "cluster")
+		gomega.Expect(planError(plan, "{}")).To(gomega.Succeed())
+	})
+})
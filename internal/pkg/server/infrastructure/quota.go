@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/quota"
+	"github.com/rs/zerolog/log"
+)
+
+// SetQuotas sets the limits of the organizations. Without quotas the infrastructure of the organizations is not
+// limited. It must be called before the manager is passed to the handler.
+func (m *Manager) SetQuotas(quotas *quota.Quotas) {
+	m.quotas = quotas
+}
+
+// quotaUsage computes the infrastructure used by an organization from the clusters and nodes in system model and
+// the ongoing operations. The nodes requested by ongoing provisions and scales are counted until their clusters are
+// updated in system model. The number of nodes of each cluster is also returned.
+func (m *Manager) quotaUsage(organizationID string) (*quota.Usage, map[string]int, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	clusters, err := m.clusterClient.ListClusters(ctx, &grpc_organization_go.OrganizationId{OrganizationId: organizationID})
+	if err != nil {
+		return nil, nil, conversions.ToDerror(err)
+	}
+	operations := m.operations.List(organizationID, "")
+	usage := &quota.Usage{
+		Clusters:   len(clusters.Clusters),
+		Operations: len(operations),
+	}
+	clusterNodes := make(map[string]int, len(clusters.Clusters))
+	for _, cluster := range clusters.Clusters {
+		nodes, nErr := m.nodesClient.ListNodes(ctx, &grpc_infrastructure_go.ClusterId{
+			OrganizationId: organizationID,
+			ClusterId:      cluster.ClusterId,
+		})
+		if nErr != nil {
+			return nil, nil, conversions.ToDerror(nErr)
+		}
+		clusterNodes[cluster.ClusterId] = len(nodes.Nodes)
+		usage.Nodes += len(nodes.Nodes)
+	}
+	for _, op := range operations {
+		requested := int(op.Nodes)
+		current, exists := clusterNodes[op.ClusterID]
+		if exists && requested > current {
+			usage.Nodes += requested - current
+			clusterNodes[op.ClusterID] = requested
+		}
+	}
+	return usage, clusterNodes, nil
+}
+
+// checkQuota checks a request against the quota of its organization. Organizations without limits are not checked.
+// Otherwise, the quota lock of the organization is held while the request is checked and, if it fits, until the
+// returned function is called, which must happen once the operation of the request is registered.
+func (m *Manager) checkQuota(organizationID string, check func(limits quota.Limits, usage quota.Usage, clusterNodes map[string]int) derrors.Error) (func(), derrors.Error) {
+	limits := m.quotas.For(organizationID)
+	if !limits.Limited() {
+		return func() {}, nil
+	}
+	release := m.quotaLocks.Acquire(organizationID)
+	usage, clusterNodes, err := m.quotaUsage(organizationID)
+	if err == nil {
+		err = check(limits, *usage, clusterNodes)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// checkProvisionQuota checks that a new cluster fits in the quota of its organization.
+func (m *Manager) checkProvisionQuota(request *grpc_provisioner_go.ProvisionClusterRequest) (func(), derrors.Error) {
+	return m.checkQuota(request.OrganizationId, func(limits quota.Limits, usage quota.Usage, clusterNodes map[string]int) derrors.Error {
+		err := limits.CheckProvision(usage, int(request.NumNodes))
+		if err != nil {
+			log.Warn().Str("organizationID", request.OrganizationId).Str("err", err.Error()).Msg("provision rejected by quota")
+		}
+		return err
+	})
+}
+
+// checkScaleQuota checks that the new size of a cluster fits in the quota of its organization.
+func (m *Manager) checkScaleQuota(request *grpc_provisioner_go.ScaleClusterRequest) (func(), derrors.Error) {
+	return m.checkQuota(request.OrganizationId, func(limits quota.Limits, usage quota.Usage, clusterNodes map[string]int) derrors.Error {
+		currentNodes, exists := clusterNodes[request.ClusterId]
+		if !exists {
+			return derrors.NewNotFoundError("cluster not found").WithParams(request.OrganizationId, request.ClusterId)
+		}
+		err := limits.CheckScale(usage, currentNodes, int(request.NumNodes))
+		if err != nil {
+			log.Warn().Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).
+				Str("err", err.Error()).Msg("scale rejected by quota")
+		}
+		return err
+	})
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+// DefaultNodeReconcileInterval is the time between two reconciliations of the nodes of the installed clusters.
+const DefaultNodeReconcileInterval = time.Minute * 5
+
+// nodeChanges contains the differences between the nodes found in Kubernetes and the ones in system model.
+type nodeChanges struct {
+	// added contains the Kubernetes nodes that are not in system model.
+	added []entities.Node
+	// removed contains the identifiers of the system model nodes that are no longer in Kubernetes.
+	removed []string
+	// relabeled contains the system model nodes whose labels differ, with the labels found in Kubernetes.
+	relabeled map[*grpc_infrastructure_go.Node]map[string]string
+}
+
+// empty checks if there are no changes.
+func (nc *nodeChanges) empty() bool {
+	return len(nc.added) == 0 && len(nc.removed) == 0 && len(nc.relabeled) == 0
+}
+
+// diffNodes compares the nodes found in Kubernetes with the nodes of a cluster in system model. Nodes are matched by
+// their address, as it is the only attribute recorded on both sides.
+func diffNodes(discovered []entities.Node, current []*grpc_infrastructure_go.Node) nodeChanges {
+	changes := nodeChanges{
+		added:     make([]entities.Node, 0),
+		removed:   make([]string, 0),
+		relabeled: make(map[*grpc_infrastructure_go.Node]map[string]string, 0),
+	}
+	byIP := make(map[string]*grpc_infrastructure_go.Node, len(current))
+	for _, node := range current {
+		byIP[node.Ip] = node
+	}
+	for _, node := range discovered {
+		existing, exists := byIP[node.IP]
+		if !exists {
+			changes.added = append(changes.added, node)
+			continue
+		}
+		delete(byIP, node.IP)
+		if !sameLabels(existing.Labels, node.Labels) {
+			changes.relabeled[existing] = node.Labels
+		}
+	}
+	for _, node := range byIP {
+		changes.removed = append(changes.removed, node.NodeId)
+	}
+	return changes
+}
+
+// sameLabels checks if two sets of labels are equal.
+func sameLabels(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, exists := b[key]; !exists || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ReconcileNodes periodically updates system model with the nodes found in the installed clusters, so that nodes
+// added by an autoscaler or removed by an administrator are reflected. Clusters whose kubeconfig is not stored cannot
+// be reached and are reported on each reconciliation. The method blocks until the manager shuts down, and it is
+// expected to run only on the leader.
+func (m *Manager) ReconcileNodes(interval time.Duration) {
+	if interval <= 0 {
+		log.Info().Msg("node reconciliation disabled")
+		return
+	}
+	log.Info().Str("interval", interval.String()).Msg("node reconciliation started")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.reconcileAllNodes()
+		case <-m.shutdown.stopped:
+			log.Info().Msg("node reconciliation stopped")
+			return
+		}
+	}
+}
+
+// reconcileAllNodes reconciles the nodes of every installed cluster.
+func (m *Manager) reconcileAllNodes() {
+	if !m.shutdown.begin() {
+		return
+	}
+	defer m.shutdown.end()
+	clusters, err := m.installedClusters()
+	if err != nil {
+		log.Error().Str("trace", err.DebugReport()).Msg("cannot list the clusters to reconcile")
+		return
+	}
+	for _, cluster := range clusters {
+		rErr := m.reconcileClusterNodes(cluster.OrganizationId, cluster.ClusterId)
+		if rErr != nil {
+			log.Warn().Str("organizationID", cluster.OrganizationId).Str("clusterID", cluster.ClusterId).
+				Str("trace", rErr.DebugReport()).Msg("cannot reconcile the nodes of the cluster")
+		}
+	}
+}
+
+// installedClusters lists the installed clusters of every organization in system model. Organizations whose
+// clusters cannot be listed are skipped.
+func (m *Manager) installedClusters() ([]*grpc_infrastructure_go.Cluster, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	organizations, err := m.orgClient.ListOrganizations(ctx, &grpc_common_go.Empty{})
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	result := make([]*grpc_infrastructure_go.Cluster, 0)
+	for _, organization := range organizations.Organizations {
+		clusters, lErr := m.ListClusters(&grpc_organization_go.OrganizationId{OrganizationId: organization.OrganizationId})
+		if lErr != nil {
+			log.Warn().Str("organizationID", organization.OrganizationId).Str("trace", conversions.ToDerror(lErr).DebugReport()).
+				Msg("cannot list the clusters of the organization")
+			continue
+		}
+		for _, cluster := range clusters.Clusters {
+			if cluster.State == grpc_infrastructure_go.ClusterState_INSTALLED {
+				result = append(result, cluster)
+			}
+		}
+	}
+	return result, nil
+}
+
+// reconcileClusterNodes discovers the nodes of an installed cluster and applies the differences to system model.
+// Clusters with an ongoing operation are skipped until the next reconciliation.
+func (m *Manager) reconcileClusterNodes(organizationID string, clusterID string) derrors.Error {
+	requestID := uuid.NewV4().String()
+	lErr := m.clusterLocks.Acquire(organizationID, clusterID, requestID, entities.ReconcileNodesOperation)
+	if lErr != nil {
+		log.Debug().Str("clusterID", clusterID).Msg("cluster has an ongoing operation, skipping node reconciliation")
+		return nil
+	}
+	defer m.clusterLocks.Release(organizationID, clusterID, requestID)
+	cluster, err := m.getCluster(organizationID, clusterID)
+	if err != nil {
+		return err
+	}
+	if cluster.State != grpc_infrastructure_go.ClusterState_INSTALLED {
+		return nil
+	}
+	kubeConfig, err := m.clusterKubeConfig(organizationID, clusterID, nil)
+	if err != nil {
+		return err
+	}
+	discovered, err := m.discoverCluster(requestID, kubeConfig, cluster.Hostname)
+	if err != nil {
+		return err
+	}
+	current, nErr := m.ListNodes(&grpc_infrastructure_go.ClusterId{
+		OrganizationId: organizationID,
+		ClusterId:      clusterID,
+	})
+	if nErr != nil {
+		return conversions.ToDerror(nErr)
+	}
+	changes := diffNodes(discovered.Nodes, current.Nodes)
+	if changes.empty() {
+		return nil
+	}
+	log.Info().Str("clusterID", clusterID).Int("added", len(changes.added)).Int("removed", len(changes.removed)).
+		Int("relabeled", len(changes.relabeled)).Msg("reconciling cluster nodes")
+	return m.applyNodeChanges(requestID, organizationID, clusterID, changes)
+}
+
+// applyNodeChanges updates system model with the changes found on the nodes of a cluster and publishes them on the bus.
+func (m *Manager) applyNodeChanges(requestID string, organizationID string, clusterID string, changes nodeChanges) derrors.Error {
+	if len(changes.added) > 0 {
+		compensation := NewCompensation(requestID)
+		err := m.attachNodes(requestID, organizationID, clusterID, &entities.Cluster{Nodes: changes.added}, compensation)
+		if err != nil {
+			cErr := compensation.Run()
+			if cErr != nil {
+				log.Error().Str("clusterID", clusterID).Msg("nodes added during the reconciliation could not be removed")
+			}
+			return err
+		}
+		for _, node := range changes.added {
+			m.sendNodeEvent(&grpc_infrastructure_go.AddNodeRequest{
+				RequestId:      requestID,
+				OrganizationId: organizationID,
+				Ip:             node.IP,
+				Labels:         node.Labels,
+			})
+		}
+	}
+	if len(changes.removed) > 0 {
+		request := &grpc_infrastructure_go.RemoveNodesRequest{
+			RequestId:      requestID,
+			OrganizationId: organizationID,
+			Nodes:          changes.removed,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer cancel()
+		_, err := m.nodesClient.RemoveNodes(ctx, request)
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		m.sendNodeEvent(request)
+	}
+	for node, labels := range changes.relabeled {
+		err := m.replaceNodeLabels(organizationID, node, labels)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceNodeLabels sets the labels of a node in system model to the ones found in Kubernetes.
+func (m *Manager) replaceNodeLabels(organizationID string, node *grpc_infrastructure_go.Node, labels map[string]string) derrors.Error {
+	stale := make(map[string]string, 0)
+	for key, value := range node.Labels {
+		if _, exists := labels[key]; !exists {
+			stale[key] = value
+		}
+	}
+	requests := make([]*grpc_infrastructure_go.UpdateNodeRequest, 0, 2)
+	if len(stale) > 0 {
+		requests = append(requests, &grpc_infrastructure_go.UpdateNodeRequest{
+			OrganizationId: organizationID,
+			NodeId:         node.NodeId,
+			RemoveLabels:   true,
+			Labels:         stale,
+		})
+	}
+	if len(labels) > 0 {
+		requests = append(requests, &grpc_infrastructure_go.UpdateNodeRequest{
+			OrganizationId: organizationID,
+			NodeId:         node.NodeId,
+			AddLabels:      true,
+			Labels:         labels,
+		})
+	}
+	for _, request := range requests {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		_, err := m.nodesClient.UpdateNode(ctx, request)
+		cancel()
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		m.sendNodeEvent(request)
+	}
+	return nil
+}
+
+// sendNodeEvent publishes on the bus a change on the nodes of a cluster.
+func (m *Manager) sendNodeEvent(event proto.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	errBus := m.busManager.SendEvents(ctx, event)
+	if errBus != nil {
+		log.Error().Str("trace", errBus.DebugReport()).Msg("error in the bus when sending a node event")
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Node reconciliation", func() {
+
+	ginkgo.It("should find the added, removed and relabeled nodes", func() {
+		kept := &grpc_infrastructure_go.Node{NodeId: "n1", Ip: "10.0.0.1", Labels: map[string]string{"zone": "a"}}
+		relabeled := &grpc_infrastructure_go.Node{NodeId: "n2", Ip: "10.0.0.2", Labels: map[string]string{"zone": "a"}}
+		gone := &grpc_infrastructure_go.Node{NodeId: "n3", Ip: "10.0.0.3"}
+		discovered := []entities.Node{
+			{IP: "10.0.0.1", Labels: map[string]string{"zone": "a"}},
+			{IP: "10.0.0.2", Labels: map[string]string{"zone": "b"}},
+			{IP: "10.0.0.4", Labels: map[string]string{"zone": "c"}},
+		}
+		changes := diffNodes(discovered, []*grpc_infrastructure_go.Node{kept, relabeled, gone})
+		gomega.Expect(changes.added).Should(gomega.Equal([]entities.Node{discovered[2]}))
+		gomega.Expect(changes.removed).Should(gomega.Equal([]string{"n3"}))
+		gomega.Expect(len(changes.relabeled)).Should(gomega.Equal(1))
+		gomega.Expect(changes.relabeled[relabeled]).Should(gomega.Equal(map[string]string{"zone": "b"}))
+	})
+
+	ginkgo.It("should not report changes on matching nodes", func() {
+		current := []*grpc_infrastructure_go.Node{{NodeId: "n1", Ip: "10.0.0.1", Labels: map[string]string{}}}
+		changes := diffNodes([]entities.Node{{IP: "10.0.0.1"}}, current)
+		gomega.Expect(changes.empty()).Should(gomega.BeTrue())
+	})
+})
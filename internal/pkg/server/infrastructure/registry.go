@@ -0,0 +1,133 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/monitor"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"sync"
+)
+
+// OperationRegistry keeps track of the operations whose monitors are running in this infrastructure manager.
+type OperationRegistry struct {
+	sync.RWMutex
+	operations map[string]*entities.Operation
+	// monitors contains the monitor following each operation.
+	monitors map[string]monitor.Monitor
+	// suspending is set once the infrastructure manager starts shutting down.
+	suspending bool
+}
+
+// NewOperationRegistry creates an empty registry.
+func NewOperationRegistry() *OperationRegistry {
+	return &OperationRegistry{
+		operations: make(map[string]*entities.Operation, 0),
+		monitors:   make(map[string]monitor.Monitor, 0),
+	}
+}
+
+// Add registers an operation replacing any previous operation with the same request identifier.
+func (r *OperationRegistry) Add(operation entities.Operation) {
+	r.Lock()
+	defer r.Unlock()
+	r.operations[operation.RequestID] = &operation
+	delete(r.monitors, operation.RequestID)
+}
+
+// AttachMonitor associates the monitor that follows an operation. If the registry is being suspended, the monitor
+// is stopped right away.
+func (r *OperationRegistry) AttachMonitor(requestID string, mon monitor.Monitor) {
+	r.Lock()
+	defer r.Unlock()
+	if r.suspending {
+		mon.Suspend()
+	}
+	r.monitors[requestID] = mon
+}
+
+// SuspendAll stops the monitors of all the operations leaving them on the remote components so that they can be
+// resumed by another instance. Monitors attached afterwards are suspended right away.
+func (r *OperationRegistry) SuspendAll() {
+	r.Lock()
+	defer r.Unlock()
+	r.suspending = true
+	for requestID, mon := range r.monitors {
+		if mon.Suspend() {
+			log.Debug().Str("requestID", requestID).Msg("monitor suspended")
+		}
+	}
+}
+
+// AddNodeResult records the outcome of one of the nodes of a remove nodes operation. It returns a copy of the updated
+// operation, or nil if the operation does not exist.
+func (r *OperationRegistry) AddNodeResult(requestID string, result entities.NodeResult) *entities.Operation {
+	r.Lock()
+	defer r.Unlock()
+	operation, exists := r.operations[requestID]
+	if !exists || operation.RemoveNodes == nil {
+		return nil
+	}
+	removal := *operation.RemoveNodes
+	removal.Results = append(append(make([]entities.NodeResult, 0, len(removal.Results)+1), removal.Results...), result)
+	operation.RemoveNodes = &removal
+	updated := *operation
+	return &updated
+}
+
+// Remove deletes an operation from the registry.
+func (r *OperationRegistry) Remove(requestID string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.operations, requestID)
+	delete(r.monitors, requestID)
+}
+
+// Get retrieves a copy of an operation.
+func (r *OperationRegistry) Get(requestID string) (*entities.Operation, derrors.Error) {
+	r.RLock()
+	defer r.RUnlock()
+	operation, exists := r.operations[requestID]
+	if !exists {
+		return nil, derrors.NewNotFoundError("operation not found").WithParams(requestID)
+	}
+	result := *operation
+	return &result, nil
+}
+
+// List retrieves the operations of an organization sorted by creation time. If the cluster identifier is not
+// empty, only the operations of that cluster are returned.
+func (r *OperationRegistry) List(organizationID string, clusterID string) []entities.Operation {
+	r.RLock()
+	defer r.RUnlock()
+	result := make([]entities.Operation, 0)
+	for _, operation := range r.operations {
+		if operation.OrganizationID != organizationID {
+			continue
+		}
+		if clusterID != "" && operation.ClusterID != clusterID {
+			continue
+		}
+		result = append(result, *operation)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created < result[j].Created
+	})
+	return result
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Operation registry", func() {
+
+	var registry *OperationRegistry
+
+	ginkgo.BeforeEach(func() {
+		registry = NewOperationRegistry()
+		registry.Add(entities.Operation{
+			RequestID:      "request",
+			OrganizationID: "org",
+			ClusterID:      "cluster",
+			Type:           entities.InstallOperation,
+		})
+	})
+
+	ginkgo.It("should record the result of each removed node", func() {
+		registry.Add(entities.Operation{
+			RequestID:      "removal",
+			OrganizationID: "org",
+			ClusterID:      "cluster",
+			Type:           entities.RemoveNodesOperation,
+			RemoveNodes:    &entities.NodeRemoval{Nodes: []string{"n1", "n2"}},
+		})
+		before, err := registry.Get("removal")
+		gomega.Expect(err).To(gomega.Succeed())
+		updated := registry.AddNodeResult("removal", entities.NodeResult{NodeID: "n1", Error: "cannot drain"})
+		gomega.Expect(updated).ShouldNot(gomega.BeNil())
+		gomega.Expect(updated.RemoveNodes.Results).Should(gomega.Equal([]entities.NodeResult{{NodeID: "n1", Error: "cannot drain"}}))
+		gomega.Expect(before.RemoveNodes.Results).Should(gomega.BeEmpty())
+		gomega.Expect(registry.AddNodeResult("request", entities.NodeResult{NodeID: "n1"})).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should list the operations of an organization by creation time", func() {
+		registry.Add(entities.Operation{
+			RequestID:      "older",
+			OrganizationID: "org",
+			ClusterID:      "other",
+			Type:           entities.ScaleOperation,
+			Created:        -1,
+		})
+		registry.Add(entities.Operation{
+			RequestID:      "otherOrg",
+			OrganizationID: "otherOrg",
+			ClusterID:      "cluster",
+			Type:           entities.ScaleOperation,
+		})
+		listed := registry.List("org", "")
+		gomega.Expect(len(listed)).Should(gomega.Equal(2))
+		gomega.Expect(listed[0].RequestID).Should(gomega.Equal("older"))
+		gomega.Expect(len(registry.List("org", "cluster"))).Should(gomega.Equal(1))
+	})
+})
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/nalej/infrastructure-manager/internal/pkg/monitor"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// DrainCheckInterval is the time between checks of the applications still deployed on a cluster being drained.
+const DrainCheckInterval = 15 * time.Second
+
+// RemoveCluster removes an imported cluster from an organization. The cluster is cordoned and drained of running
+// applications, then it is uninstalled if its kubeconfig is available and finally its nodes and the cluster are
+// removed from system model. The request returns once the removal has been validated, and its progress is published
+// on the bus. Clusters provisioned by the platform must be decommissioned instead.
+func (m *Manager) RemoveCluster(request *grpc_infrastructure_go.RemoveClusterRequest) (*grpc_common_go.Success, derrors.Error) {
+	log.Debug().Str("requestID", request.RequestId).
+		Str("organizationID", request.OrganizationId).Str("clusterID", request.ClusterId).Msg("RemoveCluster")
+	lErr := m.clusterLocks.Acquire(request.OrganizationId, request.ClusterId, request.RequestId, entities.RemoveOperation)
+	if lErr != nil {
+		return nil, lErr
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			m.clusterLocks.Release(request.OrganizationId, request.ClusterId, request.RequestId)
+		}
+	}()
+	cluster, err := m.getCluster(request.OrganizationId, request.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := m.isImported(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if !imported {
+		return nil, derrors.NewFailedPreconditionError("only imported clusters can be removed, provisioned clusters must be decommissioned").
+			WithParams(request.ClusterId)
+	}
+	// The cluster must be in a state where it can be uninstalled once drained.
+	err = entities.ValidClusterTransition(entities.UninstallOperation, cluster.State, grpc_infrastructure_go.ClusterState_UNINSTALLING)
+	if err != nil {
+		return nil, err
+	}
+	m.startOperation(entities.Operation{
+		RequestID:      request.RequestId,
+		OrganizationID: request.OrganizationId,
+		ClusterID:      request.ClusterId,
+		Type:           entities.RemoveOperation,
+		RemoveFromSM:   true,
+	})
+	op, err := m.operations.Get(request.RequestId)
+	if err != nil {
+		return nil, err
+	}
+	launched = true
+	go m.removeCluster(*op)
+	return &grpc_common_go.Success{}, nil
+}
+
+// removeCluster cordons and drains a cluster, and then triggers its uninstall. The removal of the cluster from
+// system model is done by the uninstall callback. If the manager shuts down while the cluster is being drained, the
+// operation is left in the journal and the removal starts again from the cordon once it is resumed.
+func (m *Manager) removeCluster(op entities.Operation) {
+	if !m.shutdown.begin() {
+		log.Info().Str("requestID", op.RequestID).Msg("shutting down, operation left in the journal")
+		return
+	}
+	defer m.shutdown.end()
+	drained, err := m.cordonAndDrain(op)
+	if err != nil {
+		m.removalFailed(op.RequestID, op.OrganizationID, op.ClusterID, err)
+		m.finishOperation(op.RequestID, entities.RemoveOperation)
+		return
+	}
+	if !drained {
+		log.Info().Str("requestID", op.RequestID).Msg("removal suspended")
+		return
+	}
+	kubeConfig, err := m.kubeConfigs.Get(op.OrganizationID, op.ClusterID)
+	if err != nil && err.Type() == derrors.NotFound {
+		// Clusters imported before their kubeconfig was kept cannot be uninstalled, so the platform components are
+		// left on them.
+		log.Warn().Str("requestID", op.RequestID).Str("clusterID", op.ClusterID).
+			Msg("kubeconfig of the imported cluster not available, skipping the uninstall")
+		m.reportRemoval(op.RequestID, op.OrganizationID, "UNINSTALL_SKIPPED")
+		m.removalFinished(op.RequestID, op.OrganizationID, op.ClusterID)
+		m.finishOperation(op.RequestID, entities.RemoveOperation)
+		return
+	}
+	if err == nil {
+		m.reportRemoval(op.RequestID, op.OrganizationID, "UNINSTALLING")
+		_, err = m.Uninstall(&grpc_installer_go.UninstallClusterRequest{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+			ClusterId:      op.ClusterID,
+			ClusterType:    grpc_infrastructure_go.ClusterType_KUBERNETES,
+			KubeConfigRaw:  kubeConfig,
+		}, nil, true)
+	}
+	if err != nil {
+		m.removalFailed(op.RequestID, op.OrganizationID, op.ClusterID, err)
+	}
+	// If the uninstall was launched, the operation has been replaced and this is a no-op.
+	m.finishOperation(op.RequestID, entities.RemoveOperation)
+}
+
+// cordonAndDrain blocks new deployments on a cluster and waits until its applications have been rescheduled. It
+// returns false without error if the manager shuts down while waiting.
+func (m *Manager) cordonAndDrain(op entities.Operation) (bool, derrors.Error) {
+	clusterID := &grpc_infrastructure_go.ClusterId{
+		OrganizationId: op.OrganizationID,
+		ClusterId:      op.ClusterID,
+	}
+	m.reportRemoval(op.RequestID, op.OrganizationID, "CORDONING")
+	_, err := m.CordonCluster(clusterID)
+	if err != nil {
+		return false, conversions.ToDerror(err)
+	}
+	m.reportRemoval(op.RequestID, op.OrganizationID, "DRAINING")
+	_, err = m.DrainCluster(clusterID)
+	if err != nil {
+		return false, conversions.ToDerror(err)
+	}
+	var deadline <-chan time.Time
+	if maxDuration := m.operationConfig.Deadlines[entities.RemoveOperation]; maxDuration > 0 {
+		deadlineTimer := time.NewTimer(time.Until(time.Unix(op.Created, 0).Add(maxDuration)))
+		defer deadlineTimer.Stop()
+		deadline = deadlineTimer.C
+	}
+	remainingFailures := monitor.MaxConnFailures
+	for {
+		hasApps, hErr := m.clusterHasApps(op.OrganizationID, op.ClusterID)
+		if hErr != nil {
+			remainingFailures--
+			log.Warn().Str("requestID", op.RequestID).Str("trace", hErr.DebugReport()).
+				Int("remainingFailures", remainingFailures).Msg("cannot check the applications of the cluster")
+			if remainingFailures == 0 {
+				return false, hErr
+			}
+		} else if !hasApps {
+			m.reportRemoval(op.RequestID, op.OrganizationID, "DRAINED")
+			return true, nil
+		} else {
+			remainingFailures = monitor.MaxConnFailures
+		}
+		select {
+		case <-time.After(DrainCheckInterval):
+		case <-deadline:
+			return false, derrors.NewDeadlineExceededError("cluster was not drained before the deadline").WithParams(op.ClusterID)
+		case <-m.shutdown.stopped:
+			return false, nil
+		}
+	}
+}
+
+// reportRemoval publishes the status of a removal on the bus.
+func (m *Manager) reportRemoval(requestID string, organizationID string, status string) {
+	log.Debug().Str("requestID", requestID).Str("status", status).Msg("cluster removal progress")
+	m.sendOperationEvent(&grpc_common_go.OpResponse{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		Status:         grpc_common_go.OpStatus_INPROGRESS,
+		Info:           status,
+	})
+}
+
+// removalFailed publishes the failure of a removal. The cluster is left cordoned so that no new applications are
+// deployed on it until the removal is retried or the cluster is uncordoned.
+func (m *Manager) removalFailed(requestID string, organizationID string, clusterID string, err derrors.Error) {
+	log.Error().Str("requestID", requestID).Str("clusterID", clusterID).Str("trace", err.DebugReport()).Msg("cluster removal failed")
+	m.sendOperationEvent(&grpc_common_go.OpResponse{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		Status:         grpc_common_go.OpStatus_FAILED,
+		Error:          err.Error(),
+	})
+}
+
+// removalFinished removes an uninstalled cluster and its nodes from system model, together with its kubeconfig.
+func (m *Manager) removalFinished(requestID string, organizationID string, clusterID string) {
+	err := m.removeClusterFromSM(requestID, organizationID, clusterID)
+	if err != nil {
+		m.removalFailed(requestID, organizationID, clusterID, err)
+		return
+	}
+	err = m.kubeConfigs.Remove(organizationID, clusterID)
+	if err != nil {
+		log.Warn().Str("clusterID", clusterID).Str("trace", err.DebugReport()).Msg("cannot remove the kubeconfig of the cluster")
+	}
+	log.Info().Str("requestID", requestID).Str("organizationID", organizationID).Str("clusterID", clusterID).Msg("cluster removed")
+	m.sendOperationEvent(&grpc_common_go.OpResponse{
+		RequestId:      requestID,
+		OrganizationId: organizationID,
+		Status:         grpc_common_go.OpStatus_SUCCESS,
+		Info:           "cluster removed",
+	})
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-infrastructure-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/nalej/infrastructure-manager/internal/pkg/clusterclient"
+	"github.com/nalej/infrastructure-manager/internal/pkg/entities"
+	"github.com/rs/zerolog/log"
+	"sort"
+)
+
+// ForceScaleDownHeader is the gRPC metadata key used by clients to scale down a cluster even if the remaining nodes
+// cannot host the application services of the removed ones. Set it to "true" to force the scale.
+const ForceScaleDownHeader = "force-scale-down"
+
+// ServiceInstanceLabel is the label set by the deployment manager on the pods of the application services.
+const ServiceInstanceLabel = "nalej-service-instance-id"
+
+// GetForceScaleDown checks if the client requested to force a scale down.
+func GetForceScaleDown(ctx context.Context) bool {
+	return metadataFlag(ctx, ForceScaleDownHeader)
+}
+
+// scaleDownPlan contains the expected effect of a scale down on the nodes of a cluster.
+type scaleDownPlan struct {
+	// inspected is set if the nodes of the cluster could be reached, otherwise the rest of the fields are empty.
+	inspected bool
+	// removed contains the nodes expected to be removed by the provisioner.
+	removed []clusterclient.NodeUsage
+	// services contains the application services with pods on the removed nodes.
+	services []string
+	// fits is set if the remaining nodes have enough free resources for the pods of the removed ones.
+	fits bool
+}
+
+// planScaleDown selects the nodes removed by a scale down and checks if the remaining nodes can host their pods. The
+// newest nodes are selected because the scale request does not name the nodes to remove, and the provisioner scales
+// Azure clusters through their virtual machine scale set, whose default scale-in policy removes the instances with the
+// highest identifier first. Drained nodes that the provisioner keeps are uncordoned once the scale finishes. The
+// capacity is estimated by comparing the aggregated free resources of the remaining schedulable nodes with the
+// requests of the evicted pods.
+func planScaleDown(nodes []clusterclient.NodeUsage, numNodes int, services map[string]string) scaleDownPlan {
+	plan := scaleDownPlan{
+		inspected: true,
+		removed:   make([]clusterclient.NodeUsage, 0),
+		services:  make([]string, 0),
+		fits:      true,
+	}
+	if numNodes >= len(nodes) {
+		return plan
+	}
+	sorted := make([]clusterclient.NodeUsage, len(nodes))
+	copy(sorted, nodes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+	plan.removed = sorted[:len(nodes)-numNodes]
+	var freeCPU, freeMemory, evictedCPU, evictedMemory int64
+	for _, node := range sorted[len(nodes)-numNodes:] {
+		if node.Schedulable {
+			freeCPU += node.FreeCPU()
+			freeMemory += node.FreeMemory()
+		}
+	}
+	affected := make(map[string]bool, 0)
+	for _, node := range plan.removed {
+		for _, pod := range node.Evictable {
+			cpu, memory := clusterclient.PodRequests(pod)
+			evictedCPU += cpu
+			evictedMemory += memory
+			serviceID := pod.Labels[ServiceInstanceLabel]
+			if serviceID == "" {
+				continue
+			}
+			if name, deployed := services[serviceID]; deployed && !affected[serviceID] {
+				affected[serviceID] = true
+				plan.services = append(plan.services, fmt.Sprintf("%s (%s)", name, serviceID))
+			}
+		}
+	}
+	sort.Strings(plan.services)
+	plan.fits = evictedCPU <= freeCPU && evictedMemory <= freeMemory
+	return plan
+}
+
+// blockingIssue returns the reason why the scale down cannot be performed safely, if any.
+func (p *scaleDownPlan) blockingIssue(clusterID string) derrors.Error {
+	if !p.inspected {
+		return derrors.NewFailedPreconditionError(
+			fmt.Sprintf("nodes of the cluster cannot be inspected before a scale down, set %s to force it", ForceScaleDownHeader)).
+			WithParams(clusterID)
+	}
+	if !p.fits && len(p.services) > 0 {
+		return derrors.NewFailedPreconditionError(
+			fmt.Sprintf("remaining nodes cannot host the application services of the removed nodes, set %s to force it", ForceScaleDownHeader)).
+			WithParams(p.services)
+	}
+	return nil
+}
+
+// selectScaleDownNodes returns the names of the nodes to drain before a shrinking scale request, so that their pods
+// are rescheduled before the provisioner deletes them. The request is rejected if the remaining nodes cannot host the
+// application services of the removed ones, or if the nodes cannot be inspected, unless the scale is forced.
+func (m *Manager) selectScaleDownNodes(request *grpc_provisioner_go.ScaleClusterRequest, force bool) ([]string, derrors.Error) {
+	plan, err := m.inspectScaleDown(request, true)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, nil
+	}
+	issue := plan.blockingIssue(request.ClusterId)
+	if issue != nil {
+		if !force {
+			return nil, issue
+		}
+		log.Warn().Str("clusterID", request.ClusterId).Str("issue", issue.Error()).Msg("forcing scale down")
+	}
+	nodes := make([]string, 0, len(plan.removed))
+	for _, node := range plan.removed {
+		nodes = append(nodes, node.Name)
+	}
+	return nodes, nil
+}
+
+// inspectScaleDown computes the plan of a scale request, returning nil if the request does not reduce the number of
+// nodes of the cluster. The kubeconfig of provisioned clusters is retrieved from the provisioner if it is not stored,
+// and it is only stored if store is set so that dry runs do not change the state of the manager.
+func (m *Manager) inspectScaleDown(request *grpc_provisioner_go.ScaleClusterRequest, store bool) (*scaleDownPlan, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	current, err := m.nodesClient.ListNodes(ctx, &grpc_infrastructure_go.ClusterId{
+		OrganizationId: request.OrganizationId,
+		ClusterId:      request.ClusterId,
+	})
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	if request.NumNodes >= int64(len(current.Nodes)) {
+		return nil, nil
+	}
+	kubeConfig, fetched, kErr := m.lookupKubeConfig(request.OrganizationId, request.ClusterId, scaleKubeConfigRequest(request))
+	if kErr != nil {
+		log.Warn().Str("clusterID", request.ClusterId).Str("trace", kErr.DebugReport()).Msg("cannot retrieve the kubeconfig of the cluster")
+		return &scaleDownPlan{}, nil
+	}
+	if fetched && store {
+		m.storeProvisionedKubeConfig(request.OrganizationId, request.ClusterId, kubeConfig)
+	}
+	client, kErr := clusterclient.NewClusterClient(kubeConfig)
+	if kErr != nil {
+		return nil, kErr
+	}
+	nodes, kErr := client.NodesUsage()
+	if kErr != nil {
+		return nil, kErr
+	}
+	services, kErr := m.clusterServices(request.OrganizationId, request.ClusterId)
+	if kErr != nil {
+		return nil, kErr
+	}
+	plan := planScaleDown(nodes, int(request.NumNodes), services)
+	log.Info().Str("requestID", request.RequestId).Str("clusterID", request.ClusterId).Int("removedNodes", len(plan.removed)).
+		Strs("services", plan.services).Bool("fits", plan.fits).Msg("scale down plan")
+	return &plan, nil
+}
+
+// scaleDown drains the nodes selected for a registered scale down and then sends the scale to the provisioner. If the
+// nodes cannot be drained, the scale is aborted and the cluster is returned to INSTALLED.
+func (m *Manager) scaleDown(op entities.Operation, request *grpc_provisioner_go.ScaleClusterRequest, nodes []string, force bool) {
+	if !m.shutdown.begin() {
+		m.scaleDownAborted(op, derrors.NewUnavailableError("infrastructure manager is shutting down"))
+		return
+	}
+	defer m.shutdown.end()
+	kubeConfig, err := m.clusterKubeConfig(op.OrganizationID, op.ClusterID, nil)
+	if err != nil {
+		m.scaleDownAborted(op, err)
+		return
+	}
+	client, err := clusterclient.NewClusterClient(kubeConfig)
+	if err != nil {
+		m.scaleDownAborted(op, err)
+		return
+	}
+	m.drainAndScale(op, request, client, nodes, force)
+}
+
+// drainAndScale drains the nodes of a scale down with the client of the cluster and then launches the scale.
+func (m *Manager) drainAndScale(op entities.Operation, request *grpc_provisioner_go.ScaleClusterRequest, client *clusterclient.ClusterClient, nodes []string, force bool) {
+	op, err := m.drainScaleDownNodes(op, client, nodes, force)
+	if err != nil {
+		m.scaleDownAborted(op, err)
+		return
+	}
+	_, err = m.launchScale(op, request)
+	if err != nil {
+		log.Error().Str("requestID", op.RequestID).Str("trace", err.DebugReport()).Msg("cannot launch the scale")
+		m.sendOperationEvent(&grpc_common_go.OpResponse{
+			RequestId:      op.RequestID,
+			OrganizationId: op.OrganizationID,
+			Status:         grpc_common_go.OpStatus_FAILED,
+			Error:          err.Error(),
+		})
+	}
+}
+
+// drainScaleDownNodes cordons all the nodes to be removed, so that evicted pods are not moved between them, and then
+// drains them. The cordoned nodes are recorded in the operation so that they are uncordoned once the scale finishes.
+// If a node cannot be drained the scale fails unless it is forced, and the drain is interrupted if the manager starts
+// shutting down. In both cases the cordoned nodes are uncordoned before returning.
+func (m *Manager) drainScaleDownNodes(op entities.Operation, client *clusterclient.ClusterClient, nodes []string, force bool) (entities.Operation, derrors.Error) {
+	drainTimeout := m.operationConfig.Deadlines[entities.RemoveNodesOperation]
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultRemoveNodesDeadline
+	}
+	compensation := NewCompensation(op.RequestID)
+	undo := func(err derrors.Error) (entities.Operation, derrors.Error) {
+		cErr := compensation.Run()
+		if cErr != nil {
+			log.Error().Str("requestID", op.RequestID).Str("trace", cErr.DebugReport()).Msg("cannot uncordon the nodes drained for the scale")
+		}
+		return op, err
+	}
+	for _, name := range nodes {
+		err := client.CordonNode(name)
+		if err != nil {
+			return undo(err)
+		}
+		cordoned := name
+		compensation.Add(fmt.Sprintf("cordon node %s", cordoned), func() derrors.Error {
+			return client.UncordonNode(cordoned)
+		})
+		op.Drained = append(op.Drained, cordoned)
+		m.updateOperation(op)
+	}
+	for _, name := range nodes {
+		select {
+		case <-m.shutdown.stopped:
+			return undo(derrors.NewUnavailableError("scale down interrupted by the shutdown of the infrastructure manager").WithParams(op.ClusterID))
+		default:
+		}
+		err := client.DrainNode(name, drainTimeout)
+		if err == nil {
+			continue
+		}
+		if !force {
+			return undo(err)
+		}
+		log.Warn().Str("node", name).Str("trace", err.DebugReport()).Msg("forcing scale down of a node not drained")
+	}
+	return op, nil
+}
+
+// scaleDownAborted finishes a scale down that has not been sent to the provisioner, returning the cluster to INSTALLED.
+func (m *Manager) scaleDownAborted(op entities.Operation, err derrors.Error) {
+	log.Error().Str("requestID", op.RequestID).Str("clusterID", op.ClusterID).Str("trace", err.DebugReport()).Msg("scale down aborted")
+	uErr := m.updateClusterState(entities.ScaleOperation, op.OrganizationID, op.ClusterID, grpc_infrastructure_go.ClusterState_INSTALLED)
+	if uErr != nil {
+		log.Error().Str("trace", uErr.DebugReport()).Msg("cannot update the state of the cluster after aborting the scale")
+	}
+	m.sendOperationEvent(&grpc_common_go.OpResponse{
+		RequestId:      op.RequestID,
+		OrganizationId: op.OrganizationID,
+		Status:         grpc_common_go.OpStatus_FAILED,
+		Error:          err.Error(),
+	})
+	m.finishOperation(op.RequestID, entities.ScaleOperation)
+}
+
+// resumeScaleDown undoes a scale down interrupted while its nodes were drained. Its request is not kept, so it cannot
+// be sent to the provisioner.
+func (m *Manager) resumeScaleDown(op entities.Operation) {
+	m.uncordonKeptNodes(op)
+	m.scaleDownAborted(op, derrors.NewUnavailableError("scale down interrupted while draining the nodes").WithParams(op.ClusterID))
+}
+
+// uncordonKeptNodes uncordons the nodes drained before a scale down that still exist once the scale finishes, either
+// because the scale failed or because the provisioner removed other nodes.
+func (m *Manager) uncordonKeptNodes(op entities.Operation) {
+	if len(op.Drained) == 0 {
+		return
+	}
+	kubeConfig, err := m.clusterKubeConfig(op.OrganizationID, op.ClusterID, nil)
+	if err != nil {
+		log.Error().Str("requestID", op.RequestID).Strs("nodes", op.Drained).Str("trace", err.DebugReport()).
+			Msg("cannot check the nodes drained for the scale")
+		return
+	}
+	client, err := clusterclient.NewClusterClient(kubeConfig)
+	if err != nil {
+		log.Error().Str("requestID", op.RequestID).Strs("nodes", op.Drained).Str("trace", err.DebugReport()).
+			Msg("cannot check the nodes drained for the scale")
+		return
+	}
+	kept, err := client.UncordonExistingNodes(op.Drained)
+	if err != nil {
+		log.Error().Str("requestID", op.RequestID).Strs("nodes", op.Drained).Str("trace", err.DebugReport()).
+			Msg("cannot uncordon the nodes drained for the scale")
+	}
+	if len(kept) > 0 {
+		log.Warn().Str("requestID", op.RequestID).Strs("nodes", kept).Msg("drained nodes kept after the scale have been uncordoned")
+	}
+}
+
+// clusterServices returns the name of the application services deployed on a cluster indexed by their identifier.
+func (m *Manager) clusterServices(organizationID string, clusterID string) (map[string]string, derrors.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InfrastructureManagerTimeout)
+	defer cancel()
+	instances, err := m.appClient.ListAppInstances(ctx, &grpc_organization_go.OrganizationId{
+		OrganizationId: organizationID,
+	})
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	result := make(map[string]string, 0)
+	for _, inst := range instances.Instances {
+		for _, sg := range inst.Groups {
+			for _, s := range sg.ServiceInstances {
+				if s.OrganizationId == organizationID && s.DeployedOnClusterId == clusterID {
+					result[s.ServiceInstanceId] = s.ServiceName
+				}
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/infrastructure-manager/internal/pkg/clusterclient"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+var _ = ginkgo.Describe("Scale down plan", func() {
+
+	now := time.Now()
+
+	// servicePod returns a pod of an application service requesting a given CPU.
+	servicePod := func(serviceID string, cpu string) coreV1.Pod {
+		return coreV1.Pod{
+			ObjectMeta: metaV1.ObjectMeta{Labels: map[string]string{ServiceInstanceLabel: serviceID}},
+			Spec: coreV1.PodSpec{Containers: []coreV1.Container{{
+				Resources: coreV1.ResourceRequirements{Requests: coreV1.ResourceList{
+					coreV1.ResourceCPU: resource.MustParse(cpu),
+				}},
+			}}},
+		}
+	}
+
+	// node returns a schedulable node with 1000 millicores created a given time ago.
+	node := func(name string, age time.Duration, requestedCPU int64, pods ...coreV1.Pod) clusterclient.NodeUsage {
+		return clusterclient.NodeUsage{
+			Name:           name,
+			Created:        now.Add(-age),
+			Schedulable:    true,
+			AllocatableCPU: 1000,
+			RequestedCPU:   requestedCPU,
+			Evictable:      pods,
+		}
+	}
+
+	services := map[string]string{"s1": "web", "s2": "db"}
+
+	ginkgo.It("should remove the newest nodes", func() {
+		nodes := []clusterclient.NodeUsage{
+			node("old", time.Hour, 0),
+			node("new", time.Minute, 200, servicePod("s1", "200m")),
+			node("middle", time.Minute*30, 0),
+		}
+		plan := planScaleDown(nodes, 2, services)
+		gomega.Expect(len(plan.removed)).Should(gomega.Equal(1))
+		gomega.Expect(plan.removed[0].Name).Should(gomega.Equal("new"))
+		gomega.Expect(plan.services).Should(gomega.Equal([]string{"web (s1)"}))
+		gomega.Expect(plan.fits).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should detect that the remaining nodes cannot host the services", func() {
+		nodes := []clusterclient.NodeUsage{
+			node("old", time.Hour, 900),
+			node("new", time.Minute, 600, servicePod("s1", "300m"), servicePod("s2", "300m")),
+		}
+		plan := planScaleDown(nodes, 1, services)
+		gomega.Expect(plan.fits).Should(gomega.BeFalse())
+		gomega.Expect(plan.services).Should(gomega.Equal([]string{"db (s2)", "web (s1)"}))
+	})
+
+	ginkgo.It("should not remove nodes when the cluster grows", func() {
+		plan := planScaleDown([]clusterclient.NodeUsage{node("old", time.Hour, 0)}, 3, services)
+		gomega.Expect(plan.removed).Should(gomega.BeEmpty())
+		gomega.Expect(plan.fits).Should(gomega.BeTrue())
+	})
+})
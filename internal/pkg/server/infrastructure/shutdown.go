@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package infrastructure
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is the time given to the running monitors to checkpoint their operations.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// shutdownState tracks the monitors running in the manager so that they can be stopped before exiting.
+type shutdownState struct {
+	sync.Mutex
+	stopping bool
+	// stopped is closed once the shutdown starts.
+	stopped chan struct{}
+	running sync.WaitGroup
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{
+		stopped: make(chan struct{}),
+	}
+}
+
+// begin registers a running monitor. It returns false if the manager is shutting down.
+func (s *shutdownState) begin() bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.stopping {
+		return false
+	}
+	s.running.Add(1)
+	return true
+}
+
+// end marks a monitor as finished.
+func (s *shutdownState) end() {
+	s.running.Done()
+}
+
+// isStopping returns true once the shutdown has started.
+func (s *shutdownState) isStopping() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.stopping
+}
+
+// ShuttingDown returns true once the manager has started shutting down. No new operations are accepted from then on.
+func (m *Manager) ShuttingDown() bool {
+	return m.shutdown.isStopping()
+}
+
+// checkNotShuttingDown returns an Unavailable error if the manager is shutting down so that clients retry the
+// request against another instance.
+func (m *Manager) checkNotShuttingDown() derrors.Error {
+	if m.ShuttingDown() {
+		return derrors.NewUnavailableError("infrastructure manager is shutting down")
+	}
+	return nil
+}
+
+// Shutdown stops accepting new operations and suspends the running monitors. Suspended operations are left in the
+// journal so that they are resumed once the manager starts again. Operations whose callbacks are being processed are
+// given up to timeout to finish.
+func (m *Manager) Shutdown(timeout time.Duration) derrors.Error {
+	m.shutdown.Lock()
+	if m.shutdown.stopping {
+		m.shutdown.Unlock()
+		return nil
+	}
+	m.shutdown.stopping = true
+	close(m.shutdown.stopped)
+	m.shutdown.Unlock()
+
+	log.Info().Msg("suspending running monitors")
+	m.operations.SuspendAll()
+	done := make(chan struct{})
+	go func() {
+		m.shutdown.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info().Msg("all monitors stopped")
+		return nil
+	case <-time.After(timeout):
+		return derrors.NewDeadlineExceededError("monitors did not stop before the shutdown timeout").WithParams(timeout.String())
+	}
+}
@@ -17,22 +17,49 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-infrastructure-go"
 	"github.com/nalej/grpc-infrastructure-manager-go"
 	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-provisioner-go"
+	"github.com/nalej/infrastructure-manager/internal/pkg/autoscaler"
 	"github.com/nalej/infrastructure-manager/internal/pkg/bus"
+	"github.com/nalej/infrastructure-manager/internal/pkg/journal"
+	"github.com/nalej/infrastructure-manager/internal/pkg/kubeconfig"
+	"github.com/nalej/infrastructure-manager/internal/pkg/leader"
+	"github.com/nalej/infrastructure-manager/internal/pkg/quota"
 	"github.com/nalej/infrastructure-manager/internal/pkg/server/infrastructure"
 	"github.com/nalej/nalej-bus/pkg/bus/pulsar-comcast"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// journalDir is the directory inside the temporal path where ongoing operations are persisted.
+const journalDir = "journal"
+
+// autoscalerAuditFile is the file inside the temporal path where the autoscaler decisions are recorded by default.
+const autoscalerAuditFile = "autoscaler-audit.log"
+
+// keysDir is the directory inside the temporal path where the idempotency keys are persisted.
+const keysDir = "idempotency"
+
+// kubeConfigDir is the directory inside the temporal path where the kubeconfig of the imported clusters are stored.
+const kubeConfigDir = "kubeconfig"
+
 // Service structure with the configuration and the gRPC server.
 type Service struct {
 	Configuration Config
@@ -57,6 +84,19 @@ type Clients struct {
 	ManagementClient   grpc_provisioner_go.ManagementClient
 	DecommissionClient grpc_provisioner_go.DecommissionClient
 	AppClient          grpc_application_go.ApplicationsClient
+	OrgClient          grpc_organization_go.OrganizationsClient
+	// connections contains the underlying connections so that they can be closed on shutdown.
+	connections []*grpc.ClientConn
+}
+
+// Close closes the connections with the remote services.
+func (c *Clients) Close() {
+	for _, conn := range c.connections {
+		err := conn.Close()
+		if err != nil {
+			log.Warn().Err(err).Str("target", conn.Target()).Msg("cannot close connection")
+		}
+	}
 }
 
 // GetClients creates the required connections with the remote clients.
@@ -73,22 +113,150 @@ func (s *Service) GetClients() (*Clients, derrors.Error) {
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with the provisioner")
 	}
-	cClient := grpc_infrastructure_go.NewClustersClient(smConn)
-	nClient := grpc_infrastructure_go.NewNodesClient(smConn)
-	iClient := grpc_installer_go.NewInstallerClient(insConn)
-	pClient := grpc_provisioner_go.NewProvisionClient(provConn)
-	scClient := grpc_provisioner_go.NewScaleClient(provConn)
-	mnClient := grpc_provisioner_go.NewManagementClient(provConn)
-	dcClient := grpc_provisioner_go.NewDecommissionClient(provConn)
-	appClient := grpc_application_go.NewApplicationsClient(smConn)
-
-	return &Clients{cClient, nClient, iClient,
-		pClient, scClient, mnClient,
-		dcClient, appClient}, nil
+	return &Clients{
+		ClusterClient:      grpc_infrastructure_go.NewClustersClient(smConn),
+		NodesClient:        grpc_infrastructure_go.NewNodesClient(smConn),
+		InstallerClient:    grpc_installer_go.NewInstallerClient(insConn),
+		ProvisionerClient:  grpc_provisioner_go.NewProvisionClient(provConn),
+		ScalerClient:       grpc_provisioner_go.NewScaleClient(provConn),
+		ManagementClient:   grpc_provisioner_go.NewManagementClient(provConn),
+		DecommissionClient: grpc_provisioner_go.NewDecommissionClient(provConn),
+		AppClient:          grpc_application_go.NewApplicationsClient(smConn),
+		OrgClient:          grpc_organization_go.NewOrganizationsClient(smConn),
+		connections:        []*grpc.ClientConn{smConn, insConn, provConn},
+	}, nil
+}
+
+// getKubernetesClient creates a client for the cluster where the infrastructure manager is deployed.
+func (s *Service) getKubernetesClient() (kubernetes.Interface, derrors.Error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot load in-cluster configuration, leader election requires running inside kubernetes")
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create kubernetes client")
+	}
+	return client, nil
+}
+
+// getJournal creates the journal of the ongoing operations. The journal is shared through secrets when the leader
+// election is enabled so that a new leader adopts the operations of the previous one.
+func (s *Service) getJournal(k8sClient kubernetes.Interface) (journal.Journal, derrors.Error) {
+	if s.Configuration.LeaderElection {
+		return journal.NewSecretJournal(k8sClient.CoreV1(), s.Configuration.Election.Namespace), nil
+	}
+	return journal.NewFileJournal(filepath.Join(s.Configuration.TempDir, journalDir))
+}
+
+// getKeyStore creates the store of the idempotency keys, which is shared through a ConfigMap when the leader
+// election is enabled. The expired keys are removed.
+func (s *Service) getKeyStore(k8sClient kubernetes.Interface) (journal.KeyStore, derrors.Error) {
+	var store journal.KeyStore
+	if s.Configuration.LeaderElection {
+		created, err := journal.NewConfigMapKeyStore(k8sClient.CoreV1(), s.Configuration.Election.Namespace, journal.DefaultKeysConfigMapName)
+		if err != nil {
+			return nil, err
+		}
+		store = created
+	} else {
+		created, err := journal.NewFileKeyStore(filepath.Join(s.Configuration.TempDir, keysDir))
+		if err != nil {
+			return nil, err
+		}
+		store = created
+	}
+	err := store.Purge()
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Msg("cannot purge expired idempotency keys")
+	}
+	return store, nil
+}
+
+// getKubeConfigStore creates the store of the kubeconfig of the imported clusters. The kubeconfigs are stored as
+// secrets when the leader election is enabled so that they are available to every replica.
+func (s *Service) getKubeConfigStore(k8sClient kubernetes.Interface) (kubeconfig.Store, derrors.Error) {
+	if s.Configuration.LeaderElection {
+		return kubeconfig.NewSecretStore(k8sClient.CoreV1(), s.Configuration.Election.Namespace), nil
+	}
+	return kubeconfig.NewFileStore(filepath.Join(s.Configuration.TempDir, kubeConfigDir))
+}
+
+// getAutoscalerPolicies loads the autoscaler policies, if any.
+func (s *Service) getAutoscalerPolicies() (map[string]autoscaler.Policy, derrors.Error) {
+	if s.Configuration.AutoscalerPolicies == "" {
+		return map[string]autoscaler.Policy{}, nil
+	}
+	return autoscaler.LoadPolicies(s.Configuration.AutoscalerPolicies)
+}
+
+// getQuotas loads the quotas of the organizations, if any.
+func (s *Service) getQuotas() (*quota.Quotas, derrors.Error) {
+	if s.Configuration.Quotas == "" {
+		return quota.Unlimited(), nil
+	}
+	return quota.LoadQuotas(s.Configuration.Quotas)
+}
+
+// getAutoscalerAuditLog creates the log where the autoscaler decisions are recorded.
+func (s *Service) getAutoscalerAuditLog() autoscaler.AuditLog {
+	path := s.Configuration.AutoscalerAuditLog
+	if path == "" {
+		path = filepath.Join(s.Configuration.TempDir, autoscalerAuditFile)
+	}
+	return autoscaler.NewFileAuditLog(path)
+}
+
+// shutdownOnSignal waits for SIGTERM or SIGINT, or for the leadership to be lost, and then stops the service in
+// order: new operations are rejected and the running monitors are checkpointed, the in-flight requests are drained,
+// and the remaining resources are released. The returned channel is closed once the shutdown completes.
+func (s *Service) shutdownOnSignal(manager *infrastructure.Manager, progressConsumer *bus.ProgressConsumer, clients *Clients, leadershipLost <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Info().Str("signal", sig.String()).Msg("shutting down infrastructure manager")
+		case <-leadershipLost:
+			// Exiting guarantees that this replica does not keep following operations adopted by the new leader.
+			log.Warn().Msg("leadership lost, shutting down infrastructure manager")
+		}
+		err := manager.Shutdown(s.Configuration.ShutdownTimeout)
+		if err != nil {
+			log.Warn().Str("err", err.DebugReport()).Msg("some monitors did not stop, their operations will be resumed on restart")
+		}
+		stopped := make(chan struct{})
+		go func() {
+			s.Server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			log.Info().Msg("gRPC server drained")
+		case <-time.After(s.Configuration.ShutdownTimeout):
+			log.Warn().Msg("gRPC server not drained before the shutdown timeout, closing connections")
+			s.Server.Stop()
+		}
+		if progressConsumer != nil {
+			progressConsumer.Stop()
+		}
+		clients.Close()
+		close(done)
+	}()
+	return done
 }
 
 // Run the service, launch the REST service handler.
 func (s *Service) Run() error {
+	if s.Configuration.LeaderElection && s.Configuration.Election.Identity == "" {
+		// The hostname of a pod is its name
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot obtain the hostname to use as leader election identity")
+		}
+		s.Configuration.Election.Identity = hostname
+	}
 	cErr := s.Configuration.Validate()
 	if cErr != nil {
 		log.Fatal().Str("err", cErr.DebugReport()).Msg("invalid configuration")
@@ -117,13 +285,107 @@ func (s *Service) Run() error {
 	}
 	log.Info().Msg("done")
 
+	// The progress consumer is nil unless the progress events are enabled, in which case the monitors are also
+	// triggered by the events published by the provisioner and the installer.
+	var progressConsumer *bus.ProgressConsumer
+	if s.Configuration.ProgressEvents {
+		log.Info().Msg("instantiate progress consumer...")
+		progressConsumer, err = bus.NewProgressConsumer(queueClient, "InfrastructureManager")
+		if err != nil {
+			log.Panic().Err(err).Msg("impossible to create progress consumer instance")
+			return err
+		}
+		progressConsumer.Run()
+	}
+	log.Info().Msg("done")
+
+	var k8sClient kubernetes.Interface
+	if s.Configuration.LeaderElection {
+		client, kErr := s.getKubernetesClient()
+		if kErr != nil {
+			log.Fatal().Str("err", kErr.DebugReport()).Msg("cannot create kubernetes client")
+			return kErr
+		}
+		k8sClient = client
+	}
+
+	opJournal, jErr := s.getJournal(k8sClient)
+	if jErr != nil {
+		log.Fatal().Str("err", jErr.DebugReport()).Msg("cannot create operation journal")
+		return jErr
+	}
+
+	keys, kErr := s.getKeyStore(k8sClient)
+	if kErr != nil {
+		log.Fatal().Str("err", kErr.DebugReport()).Msg("cannot create idempotency key store")
+		return kErr
+	}
+
+	kubeConfigs, kErr := s.getKubeConfigStore(k8sClient)
+	if kErr != nil {
+		log.Fatal().Str("err", kErr.DebugReport()).Msg("cannot create kubeconfig store")
+		return kErr
+	}
+
+	policies, pErr := s.getAutoscalerPolicies()
+	if pErr != nil {
+		log.Fatal().Str("err", pErr.DebugReport()).Msg("cannot load autoscaler policies")
+		return pErr
+	}
+	auditLog := s.getAutoscalerAuditLog()
+
+	quotas, qErr := s.getQuotas()
+	if qErr != nil {
+		log.Fatal().Str("err", qErr.DebugReport()).Msg("cannot load quotas")
+		return qErr
+	}
+
 	// Create handlers
 	manager := infrastructure.NewManager(
 		s.Configuration.TempDir,
 		clients.ClusterClient, clients.NodesClient, clients.InstallerClient,
 		clients.ProvisionerClient, clients.ScalerClient, clients.ManagementClient,
-		clients.DecommissionClient, clients.AppClient, busManager)
-	handler := infrastructure.NewHandler(manager)
+		clients.DecommissionClient, clients.AppClient, clients.OrgClient, busManager, progressConsumer, opJournal, kubeConfigs,
+		s.Configuration.GetOperationConfig())
+	manager.SetQuotas(quotas)
+
+	var handler *infrastructure.Handler
+	resume := func() {
+		log.Info().Msg("resuming ongoing operations...")
+		rErr := handler.Manager.ResumeOperations()
+		if rErr != nil {
+			log.Error().Str("err", rErr.DebugReport()).Msg("cannot resume ongoing operations")
+		}
+		go handler.Manager.ReconcileNodes(s.Configuration.NodeReconcileInterval)
+		go handler.Manager.Autoscale(s.Configuration.AutoscalerInterval, policies, auditLog)
+	}
+	leadershipLost := make(chan struct{})
+	var elector *leader.Elector
+	if s.Configuration.LeaderElection {
+		var lostOnce sync.Once
+		created, eErr := leader.NewElector(k8sClient, s.Configuration.Election, leader.Callbacks{
+			OnStartedLeading: resume,
+			OnStoppedLeading: func() {
+				lostOnce.Do(func() { close(leadershipLost) })
+			},
+		})
+		if eErr != nil {
+			log.Fatal().Str("err", eErr.DebugReport()).Msg("cannot create leader elector")
+			return eErr
+		}
+		elector = created
+		manager.SetLeadership(elector)
+	}
+	handler = infrastructure.NewHandler(manager, s.Configuration.IdempotencyKeyRetention, keys)
+
+	if elector != nil {
+		electionCtx, cancelElection := context.WithCancel(context.Background())
+		defer cancelElection()
+		log.Info().Str("identity", s.Configuration.Election.Identity).Msg("joining leader election...")
+		go elector.Run(electionCtx)
+	} else {
+		resume()
+	}
 
 	grpc_infrastructure_manager_go.RegisterInfrastructureManagerServer(s.Server, handler)
 
@@ -133,9 +395,13 @@ func (s *Service) Run() error {
 		reflection.Register(s.Server)
 	}
 
+	shutdownDone := s.shutdownOnSignal(&handler.Manager, progressConsumer, clients, leadershipLost)
+
 	log.Info().Int("port", s.Configuration.Port).Msg("Launching gRPC server")
 	if err := s.Server.Serve(lis); err != nil {
 		log.Fatal().Errs("failed to serve: %v", []error{err})
 	}
+	<-shutdownDone
+	log.Info().Msg("infrastructure manager stopped")
 	return nil
 }